@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// accessLogFormat selects how accessLog writes each request: "json",
+// Apache "common" or "combined", or "off".
+var accessLogFormat = "json"
+
+var accessLogFormats = []string{"json", "common", "combined", "off"}
+
+// accessLogger writes to stdout without the timestamp prefix of the
+// application log, since every format carries its own.
+var accessLogger = log.New(os.Stdout, "", 0)
+
+type accessLogEntry struct {
+	Time       string  `json:"time"`
+	ClientIP   string  `json:"client_ip"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+}
+
+// accessLog logs every request in format once it has been served. It
+// should wrap gzipResponses so that the bytes logged are the bytes sent.
+func accessLog(format string, next http.Handler) http.Handler {
+	if format == "off" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		// Logged from a defer so that requests whose handler panicked are
+		// logged too.
+		completed := false
+		defer func() { logAccess(format, r, start, recorder, completed) }()
+		next.ServeHTTP(recorder, r)
+		completed = true
+	})
+}
+
+// logAccess writes the log line of a request served through recorder. A
+// request that did not complete had its handler panic, and is answered by
+// recoverPanics with a 500 unless the response was already started.
+func logAccess(format string, r *http.Request, start time.Time, recorder *statusRecorder, completed bool) {
+	status := recorder.status
+	if status == 0 && completed {
+		status = http.StatusOK
+	} else if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	entry := accessLogEntry{
+		Time:       start.UTC().Format(time.RFC3339Nano),
+		ClientIP:   clientIP(r),
+		Method:     r.Method,
+		Path:       r.URL.RequestURI(),
+		Status:     status,
+		Bytes:      recorder.bytes,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+	}
+
+	switch format {
+	case "json":
+		var line strings.Builder
+		encoder := json.NewEncoder(&line)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(entry)
+		accessLogger.Print(line.String())
+	case "common":
+		accessLogger.Print(commonLogLine(r, start, entry))
+	case "combined":
+		accessLogger.Printf("%s %q %q", commonLogLine(r, start, entry), orDash(entry.Referer), orDash(entry.UserAgent))
+	}
+}
+
+// commonLogLine formats entry in the Apache Common Log Format, with "-"
+// for a response without a body.
+func commonLogLine(r *http.Request, start time.Time, entry accessLogEntry) string {
+	bytes := "-"
+	if entry.Bytes > 0 {
+		bytes = fmt.Sprint(entry.Bytes)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s",
+		entry.ClientIP, start.Format("02/Jan/2006:15:04:05 -0700"), entry.Method, entry.Path, r.Proto, entry.Status, bytes)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// statusRecorder remembers the status and counts the body bytes written
+// through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// requireAdminToken guards operator endpoints with the shared token in
+// -admin-token or ADMIN_TOKEN, sent in the X-Admin-Token header. Without a
+// configured token the endpoints are disabled.
+func requireAdminToken(token string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				http.Error(w, "Admin endpoints are disabled", http.StatusForbidden)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(token)) != 1 {
+				http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+type cacheContentsResponse struct {
+	Entries int      `json:"entries"`
+	Keys    []string `json:"keys"`
+}
+
+func cacheContentsHandler(w http.ResponseWriter, r *http.Request) {
+	keys := searchCache.Keys()
+	sort.Strings(keys)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cacheContentsResponse{Entries: len(keys), Keys: keys})
+}
+
+type searchCountsResponse struct {
+	Searches int64            `json:"searches"`
+	Places   map[string]int64 `json:"places"`
+}
+
+// searchCountsHandler lists searches by place including raw queries, which
+// /stats leaves out.
+func searchCountsHandler(w http.ResponseWriter, r *http.Request) {
+	total, byPlace := searchCounts.snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(searchCountsResponse{Searches: total, Places: byPlace})
+}
+
+type cacheFlushResponse struct {
+	Flushed int `json:"flushed"`
+}
+
+func cacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	flushed := searchCache.Flush()
+	log.Printf("Flushed %d cached searches", flushed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cacheFlushResponse{Flushed: flushed})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// parseAttributeFilter parses a filter parameter of comma-separated
+// key=value predicates, such as "pool=true,beach=near", into the canonical
+// form stored in searchOptions.Filter: lowercased keys, sorted by key.
+func parseAttributeFilter(raw string) (string, error) {
+	predicates := make(map[string]string)
+	for _, part := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(part, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if !ok || key == "" || value == "" || strings.Contains(value, "=") {
+			return "", fmt.Errorf("Query parameter 'filter' has malformed predicate %q, expected key=value", strings.TrimSpace(part))
+		}
+		if existing, seen := predicates[key]; seen && !strings.EqualFold(existing, value) {
+			return "", fmt.Errorf("Query parameter 'filter' gives attribute %q more than one value", key)
+		}
+		predicates[key] = value
+	}
+
+	keys := make([]string, 0, len(predicates))
+	for key := range predicates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		keys[i] = key + "=" + predicates[key]
+	}
+	return strings.Join(keys, ","), nil
+}
+
+// matchesAttributes reports whether p satisfies every predicate in a
+// filter produced by parseAttributeFilter. Keys and values compare
+// case-insensitively, and a property without the attribute never matches.
+func (p Property) matchesAttributes(filter string) bool {
+	if filter == "" {
+		return true
+	}
+	for _, predicate := range strings.Split(filter, ",") {
+		key, want, _ := strings.Cut(predicate, "=")
+		if !strings.EqualFold(p.attribute(key), want) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Property) attribute(key string) string {
+	for name, value := range p.Attributes {
+		if strings.EqualFold(name, key) {
+			return value
+		}
+	}
+	return ""
+}
+
+// propertyTypes are the kinds of stay the type filter accepts.
+var propertyTypes = []string{"hostel", "resort"}
+
+// propertyTypeKeywords classifies a property by the words in its name. The
+// catalog is a hostel chain whose other stays are named for what they are,
+// so a name with none of these words is a hostel.
+var propertyTypeKeywords = map[string]string{
+	"hostel":   "hostel",
+	"resort":   "resort",
+	"luxuria":  "resort",
+	"retreat":  "resort",
+	"verandah": "resort",
+}
+
+const defaultPropertyType = "hostel"
+
+// propertyType classifies p. A "type" attribute in the catalog overrides
+// the keywords in its name.
+func (p Property) propertyType() string {
+	if explicit := p.attribute("type"); explicit != "" {
+		return strings.ToLower(explicit)
+	}
+	for _, word := range tokenize(NormalizeQuery(p.Name)) {
+		if kind, exists := propertyTypeKeywords[word]; exists {
+			return kind
+		}
+	}
+	return defaultPropertyType
+}
+
+// parsePropertyTypes reads a type parameter of comma-separated
+// propertyTypes into the sorted, comma-joined form of searchOptions.Types.
+func parsePropertyTypes(raw string) (string, error) {
+	var kinds []string
+	for _, kind := range strings.Split(raw, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		if !slices.Contains(propertyTypes, kind) {
+			return "", fmt.Errorf("Query parameter 'type' must be one or more of %s", strings.Join(propertyTypes, ", "))
+		}
+		if !slices.Contains(kinds, kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	return strings.Join(kinds, ","), nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestParseAttributeFilter(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"pool=true", "pool=true", false},
+		{" Pool = true , beach=near", "beach=near,pool=true", false},
+		{"pool=true,POOL=true", "pool=true", false},
+		{"pool=true,pool=false", "", true},
+		{"pool", "", true},
+		{"=true", "", true},
+		{"pool=", "", true},
+		{"pool=a=b", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseAttributeFilter(tt.raw)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseAttributeFilter(%q) = %q, %v; want %q, error %v", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMatchesAttributes(t *testing.T) {
+	prop := Property{Name: "Lake Stay", Attributes: map[string]string{"Pool": "True", "beach": "near"}}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"", true},
+		{"pool=true", true},
+		{"beach=near,pool=true", true},
+		{"beach=far,pool=true", false},
+		{"wifi=true", false},
+	}
+	for _, tt := range tests {
+		if got := prop.matchesAttributes(tt.filter); got != tt.want {
+			t.Errorf("matchesAttributes(%q) = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestSearchAttributeFilter(t *testing.T) {
+	withCatalog(t, []Property{
+		{Name: "Pool Stay", Latitude: 24.58, Longitude: 73.68, Attributes: map[string]string{"pool": "true", "beach": "near"}},
+		{Name: "Dry Stay", Latitude: 24.59, Longitude: 73.69, Attributes: map[string]string{"pool": "false"}},
+		{Name: "Plain Stay", Latitude: 24.60, Longitude: 73.70},
+	})
+	tests := []struct {
+		filter     string
+		wantStatus int
+		want       []string
+	}{
+		{"pool=true", http.StatusOK, []string{"Pool Stay"}},
+		{"pool=false", http.StatusOK, []string{"Dry Stay"}},
+		{"pool=true,beach=far", http.StatusOK, []string{}},
+		{"pool", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		withFreshCache(t)
+		rec, response := getSearch(t, "q=udaipur&filter="+url.QueryEscape(tt.filter))
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("filter=%s: status %d, want %d: %s", tt.filter, rec.Code, tt.wantStatus, rec.Body.String())
+		}
+		if got := resultNames(response); tt.wantStatus == http.StatusOK && !slices.Equal(got, tt.want) {
+			t.Errorf("filter=%s: got %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestPropertyType(t *testing.T) {
+	tests := []struct {
+		prop Property
+		want string
+	}{
+		{Property{Name: "Moustache Udaipur"}, "hostel"},
+		{Property{Name: "Moustache Udaipur Luxuria"}, "resort"},
+		{Property{Name: "Moustache Udaipur Verandah"}, "resort"},
+		{Property{Name: "Goa Beach Resort"}, "resort"},
+		{Property{Name: "Jungle Hostel & Retreat"}, "hostel"},
+		{Property{Name: "Moustache Udaipur", Attributes: map[string]string{"Type": "Resort"}}, "resort"},
+	}
+	for _, tt := range tests {
+		if got := tt.prop.propertyType(); got != tt.want {
+			t.Errorf("propertyType(%q) = %q, want %q", tt.prop.Name, got, tt.want)
+		}
+	}
+}
+
+func TestParsePropertyTypes(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"hostel", "hostel", false},
+		{"Resort, hostel,resort", "hostel,resort", false},
+		{"villa", "", true},
+		{"hostel,", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parsePropertyTypes(tt.raw)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parsePropertyTypes(%q) = %q, %v; want %q, error %v", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSearchTypeFilter(t *testing.T) {
+	withFreshCache(t)
+	tests := []struct {
+		kinds string
+		want  []string
+	}{
+		{"hostel", []string{"Moustache Udaipur"}},
+		{"resort", []string{"Moustache Udaipur Luxuria", "Moustache Udaipur Verandah"}},
+		{"hostel,resort", []string{"Moustache Udaipur", "Moustache Udaipur Luxuria", "Moustache Udaipur Verandah"}},
+	}
+	for _, tt := range tests {
+		rec, response := getSearch(t, "q=udaipur&type="+tt.kinds)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("type=%s: status %d: %s", tt.kinds, rec.Code, rec.Body.String())
+		}
+		got := resultNames(response)
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("type=%s: got %v, want %v", tt.kinds, got, tt.want)
+		}
+	}
+	if rec, _ := getSearch(t, "q=udaipur&type=villa"); rec.Code != http.StatusBadRequest {
+		t.Errorf("type=villa: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache stores computed search responses by cache key, along with counters
+// that need to be shared by every instance using the same cache.
+type Cache interface {
+	Get(key string) (SearchResponse, bool)
+	Set(key string, response SearchResponse)
+	// Incr adds one to the counter at key and returns the new value. The
+	// counter expires after ttl.
+	Incr(key string, ttl time.Duration) int64
+	// Keys lists the keys of the cached responses; counters are not included.
+	Keys() []string
+	// Flush drops every cached response and returns how many there were.
+	Flush() int
+	// SetValue stores an arbitrary string under key until ttl passes.
+	SetValue(key, value string, ttl time.Duration)
+	GetValue(key string) (string, bool)
+}
+
+type memoryCache struct {
+	mu      sync.RWMutex
+	entries map[string]SearchResponse
+
+	countersMu sync.Mutex
+	counters   map[string]memoryCounter
+	values     map[string]memoryValue
+	nextSweep  time.Time
+}
+
+type memoryValue struct {
+	value   string
+	expires time.Time
+}
+
+type memoryCounter struct {
+	value   int64
+	expires time.Time
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{
+		entries:  make(map[string]SearchResponse),
+		counters: make(map[string]memoryCounter),
+		values:   make(map[string]memoryValue),
+	}
+}
+
+func (c *memoryCache) Get(key string) (SearchResponse, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	response, exists := c.entries[key]
+	return response, exists
+}
+
+func (c *memoryCache) Set(key string, response SearchResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = response
+}
+
+func (c *memoryCache) Keys() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (c *memoryCache) Flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	flushed := len(c.entries)
+	c.entries = make(map[string]SearchResponse)
+	return flushed
+}
+
+func (c *memoryCache) Incr(key string, ttl time.Duration) int64 {
+	c.countersMu.Lock()
+	defer c.countersMu.Unlock()
+
+	now := time.Now()
+	c.sweepLocked(now)
+	counter, exists := c.counters[key]
+	if !exists || now.After(counter.expires) {
+		counter = memoryCounter{expires: now.Add(ttl)}
+	}
+	counter.value++
+	c.counters[key] = counter
+	return counter.value
+}
+
+func (c *memoryCache) SetValue(key, value string, ttl time.Duration) {
+	c.countersMu.Lock()
+	defer c.countersMu.Unlock()
+	now := time.Now()
+	c.sweepLocked(now)
+	c.values[key] = memoryValue{value: value, expires: now.Add(ttl)}
+}
+
+func (c *memoryCache) GetValue(key string) (string, bool) {
+	c.countersMu.Lock()
+	defer c.countersMu.Unlock()
+	entry, exists := c.values[key]
+	if !exists || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.value, true
+}
+
+// sweepLocked drops expired counters and values at most once a minute.
+// c.countersMu must be held.
+func (c *memoryCache) sweepLocked(now time.Time) {
+	if now.Before(c.nextSweep) {
+		return
+	}
+	for k, counter := range c.counters {
+		if now.After(counter.expires) {
+			delete(c.counters, k)
+		}
+	}
+	for k, value := range c.values {
+		if now.After(value.expires) {
+			delete(c.values, k)
+		}
+	}
+	c.nextSweep = now.Add(time.Minute)
+}
+
+// redisCache shares cached responses between instances. Redis errors are
+// logged and treated as misses so a cache outage never fails a search.
+type redisCache struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// redisEntry carries the fields of a SearchResponse that are hidden from
+// clients and so not part of its JSON encoding.
+type redisEntry struct {
+	Response     SearchResponse `json:"response"`
+	Unrecognized bool           `json:"unrecognized,omitempty"`
+}
+
+func newRedisCache(addr, password string, ttl time.Duration) *redisCache {
+	client := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     password,
+		DialTimeout:  500 * time.Millisecond,
+		ReadTimeout:  200 * time.Millisecond,
+		WriteTimeout: 200 * time.Millisecond,
+	})
+	return &redisCache{client: client, prefix: "search:", ttl: ttl}
+}
+
+func (c *redisCache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+func (c *redisCache) Get(key string) (SearchResponse, bool) {
+	data, err := c.client.Get(context.Background(), c.prefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Redis get failed for %q: %v", key, err)
+		}
+		return SearchResponse{}, false
+	}
+	var entry redisEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		log.Printf("Discarding corrupt cache entry %q: %v", key, err)
+		return SearchResponse{}, false
+	}
+	entry.Response.Unrecognized = entry.Unrecognized
+	return entry.Response, true
+}
+
+func (c *redisCache) Set(key string, response SearchResponse) {
+	data, err := json.Marshal(redisEntry{Response: response, Unrecognized: response.Unrecognized})
+	if err != nil {
+		log.Printf("Encoding cache entry %q: %v", key, err)
+		return
+	}
+	if err := c.client.Set(context.Background(), c.prefix+key, data, c.ttl).Err(); err != nil {
+		log.Printf("Redis set failed for %q: %v", key, err)
+	}
+}
+
+// Incr falls back to 0 when Redis is unreachable so callers enforcing limits
+// fail open. The expiry is refreshed on every increment, so callers should
+// pass a ttl that ends at a fixed point in time.
+func (c *redisCache) Incr(key string, ttl time.Duration) int64 {
+	ctx := context.Background()
+	pipe := c.client.TxPipeline()
+	incr := pipe.Incr(ctx, "counter:"+key)
+	pipe.Expire(ctx, "counter:"+key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Redis incr failed for %q: %v", key, err)
+		return 0
+	}
+	return incr.Val()
+}
+
+func (c *redisCache) SetValue(key, value string, ttl time.Duration) {
+	if err := c.client.Set(context.Background(), "value:"+key, value, ttl).Err(); err != nil {
+		log.Printf("Redis set failed for %q: %v", key, err)
+	}
+}
+
+func (c *redisCache) GetValue(key string) (string, bool) {
+	value, err := c.client.Get(context.Background(), "value:"+key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Redis get failed for %q: %v", key, err)
+		}
+		return "", false
+	}
+	return value, true
+}
+
+func (c *redisCache) Keys() []string {
+	var keys []string
+	iter := c.client.Scan(context.Background(), 0, c.prefix+"*", 1000).Iterator()
+	for iter.Next(context.Background()) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), c.prefix))
+	}
+	if err := iter.Err(); err != nil {
+		log.Printf("Redis scan failed: %v", err)
+	}
+	return keys
+}
+
+// Flush deletes the entries found by Keys, so entries written by other
+// instances meanwhile may survive.
+func (c *redisCache) Flush() int {
+	keys := c.Keys()
+	flushed := 0
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), 1000)]
+		keys = keys[len(batch):]
+		for i, key := range batch {
+			batch[i] = c.prefix + key
+		}
+		deleted, err := c.client.Del(context.Background(), batch...).Result()
+		if err != nil {
+			log.Printf("Redis delete failed: %v", err)
+			break
+		}
+		flushed += int(deleted)
+	}
+	return flushed
+}
+
+// insertionGuard stops new search responses from being cached while more
+// than Limit insertions are attempted per Window, so a flood of unique
+// queries cannot fill the cache with junk. Caching resumes once a whole
+// window stays within the limit. A zero Limit disables the guard.
+type insertionGuard struct {
+	Limit  int
+	Window time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	attempts    int
+	previous    int
+	paused      bool
+}
+
+var searchCacheGuard = &insertionGuard{Window: time.Minute}
+
+// allow records an insertion attempt and reports whether to cache it.
+func (g *insertionGuard) allow() bool {
+	if g.Limit <= 0 {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	if elapsed := now.Sub(g.windowStart); elapsed >= g.Window {
+		g.previous = g.attempts
+		if elapsed >= 2*g.Window {
+			g.previous = 0
+		}
+		g.windowStart, g.attempts = now, 0
+	}
+	g.attempts++
+
+	paused := g.attempts > g.Limit || g.previous > g.Limit
+	if paused != g.paused {
+		g.paused = paused
+		if paused {
+			log.Printf("Cache insertions exceeded %d per %v, serving new searches without caching them", g.Limit, g.Window)
+		} else {
+			log.Printf("Cache insertion rate is back under %d per %v, caching again", g.Limit, g.Window)
+		}
+	}
+	return !paused
+}
+
+// Paused reports whether the guard is currently refusing insertions.
+func (g *insertionGuard) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// boundingBox is a latitude/longitude rectangle. It implements flag.Value
+// as "minLat,maxLat,minLon,maxLon".
+type boundingBox struct {
+	MinLat, MaxLat, MinLon, MaxLon float64
+}
+
+// plausibleBounds is where properties are expected to be; roughly India.
+var plausibleBounds = boundingBox{MinLat: 6, MaxLat: 37, MinLon: 68, MaxLon: 98}
+
+func (b *boundingBox) String() string {
+	return fmt.Sprintf("%g,%g,%g,%g", b.MinLat, b.MaxLat, b.MinLon, b.MaxLon)
+}
+
+func (b *boundingBox) Set(value string) error {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return errors.New("want minLat,maxLat,minLon,maxLon")
+	}
+	var bounds [4]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", part)
+		}
+		bounds[i] = v
+	}
+	if bounds[0] > bounds[1] || bounds[2] > bounds[3] {
+		return errors.New("minimums must not exceed maximums")
+	}
+	*b = boundingBox{MinLat: bounds[0], MaxLat: bounds[1], MinLon: bounds[2], MaxLon: bounds[3]}
+	return nil
+}
+
+func (b boundingBox) contains(lat, lon float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lon >= b.MinLon && lon <= b.MaxLon
+}
+
+// warnImplausibleProperties logs every property outside bounds, which
+// usually means a sign error or swapped latitude and longitude.
+func warnImplausibleProperties(props []Property, bounds boundingBox) {
+	for _, prop := range props {
+		if bounds.contains(prop.Latitude, prop.Longitude) {
+			continue
+		}
+		if bounds.contains(prop.Longitude, prop.Latitude) {
+			log.Printf("Warning: %q at (%v, %v) is outside %s; latitude and longitude look swapped", prop.Name, prop.Latitude, prop.Longitude, bounds.String())
+		} else {
+			log.Printf("Warning: %q at (%v, %v) is outside %s", prop.Name, prop.Latitude, prop.Longitude, bounds.String())
+		}
+	}
+}
+
+// properties and skippedProperties are replaced as a whole on reload, so
+// readers only need catalogMu to take a consistent snapshot.
+var (
+	catalogMu         sync.RWMutex
+	catalogGeneration int
+	// skippedProperties counts the entries dropped by a lenient catalog load.
+	skippedProperties int
+)
+
+func currentProperties() []Property {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	return properties
+}
+
+func catalogVersion() int {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+	return catalogGeneration
+}
+
+// setCatalog replaces the served properties and drops everything derived
+// from the previous catalog.
+func setCatalog(props []Property, skipped int) {
+	catalogMu.Lock()
+	// The memo is cleared before the new catalog is published: a search
+	// that already sees the new catalog version must not get a ranking of
+	// the old one, and one still ranking the old catalog holds a stale
+	// memo generation, so it cannot store its result.
+	resetRankings()
+	properties = props
+	skippedProperties = skipped
+	catalogGeneration++
+	catalogMu.Unlock()
+
+	warnImplausibleProperties(props, plausibleBounds)
+	warmCityRankings()
+	// Index the new property names now rather than in the first search.
+	currentFuzzyIndex()
+}
+
+func reloadCatalog() error {
+	loaded, skipped, err := loadProperties(propertiesFile, validationMode)
+	if err != nil {
+		return err
+	}
+	setCatalog(loaded, skipped)
+	log.Printf("Loaded %d properties from %s, skipped %d invalid entries", len(loaded), propertiesFile, skipped)
+	return nil
+}
+
+// reloadCatalogOnSIGHUP re-reads -properties-file whenever the process
+// receives SIGHUP, keeping the current catalog if the file is invalid.
+func reloadCatalogOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if err := reloadCatalog(); err != nil {
+			log.Printf("Reloading properties failed, keeping the current catalog: %v", err)
+		}
+	}
+}
+
+func validateProperty(p Property) error {
+	switch {
+	case p.Name == "":
+		return errors.New("missing name")
+	case math.IsNaN(p.Latitude) || p.Latitude < -90 || p.Latitude > 90:
+		return fmt.Errorf("latitude %v out of range", p.Latitude)
+	case math.IsNaN(p.Longitude) || p.Longitude < -180 || p.Longitude > 180:
+		return fmt.Errorf("longitude %v out of range", p.Longitude)
+	}
+	if p.Rating != 0 && (math.IsNaN(p.Rating) || p.Rating < minRating || p.Rating > maxRating) {
+		return fmt.Errorf("rating %v out of range", p.Rating)
+	}
+	if kind := p.attribute("type"); kind != "" && !slices.Contains(propertyTypes, strings.ToLower(kind)) {
+		return fmt.Errorf("type %q is not one of %s", kind, strings.Join(propertyTypes, ", "))
+	}
+	for _, alias := range p.Aliases {
+		if strings.TrimSpace(alias) == "" {
+			return errors.New("empty alias")
+		}
+	}
+	for _, month := range p.OpenMonths {
+		if month < 1 || month > 12 {
+			return fmt.Errorf("open month %d out of range", month)
+		}
+	}
+	return nil
+}
+
+// loadProperties reads a JSON array of properties. In "strict" mode any
+// invalid entry fails the whole load; in "lenient" mode invalid entries are
+// logged and skipped, and their number is returned.
+func loadProperties(path, mode string) ([]Property, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	var entries []Property
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, 0, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	loaded := make([]Property, 0, len(entries))
+	skipped := 0
+	for i, entry := range entries {
+		if err := validateProperty(entry); err != nil {
+			if mode == "strict" {
+				return nil, 0, fmt.Errorf("property %d (%q): %w", i, entry.Name, err)
+			}
+			log.Printf("Skipping property %d (%q): %v", i, entry.Name, err)
+			skipped++
+			continue
+		}
+		loaded = append(loaded, entry)
+	}
+	return loaded, skipped, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withCatalog serves props for the duration of a test.
+func withCatalog(t testing.TB, props []Property) {
+	t.Helper()
+	catalogMu.RLock()
+	previous, previousSkipped := properties, skippedProperties
+	catalogMu.RUnlock()
+	setCatalog(props, 0)
+	t.Cleanup(func() { setCatalog(previous, previousSkipped) })
+}
+
+const mixedCatalog = `[
+	{"name": "Valid Stay", "latitude": 24.58, "longitude": 73.68},
+	{"name": "", "latitude": 24.58, "longitude": 73.68},
+	{"name": "Off The Map", "latitude": 124.58, "longitude": 73.68},
+	{"name": "Wrong Way Round", "latitude": 24.58, "longitude": 273.68},
+	{"name": "Another Valid Stay", "latitude": 26.9, "longitude": 75.8}
+]`
+
+func TestLoadPropertiesValidationModes(t *testing.T) {
+	path := writeTempFile(t, "properties.json", mixedCatalog)
+	tests := []struct {
+		mode        string
+		wantErr     string
+		wantLoaded  int
+		wantSkipped int
+	}{
+		{mode: "strict", wantErr: "missing name"},
+		{mode: "lenient", wantLoaded: 2, wantSkipped: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			loaded, skipped, err := loadProperties(path, tt.mode)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(loaded) != tt.wantLoaded || skipped != tt.wantSkipped {
+				t.Errorf("loaded %d and skipped %d, want %d and %d", len(loaded), skipped, tt.wantLoaded, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestValidateProperty(t *testing.T) {
+	tests := []struct {
+		name    string
+		prop    Property
+		wantErr bool
+	}{
+		{"valid", Property{Name: "A", Latitude: 24.5, Longitude: 73.6}, false},
+		{"missing name", Property{Latitude: 24.5, Longitude: 73.6}, true},
+		{"latitude too high", Property{Name: "A", Latitude: 91, Longitude: 73.6}, true},
+		{"longitude too low", Property{Name: "A", Latitude: 24.5, Longitude: -181}, true},
+		{"rating out of range", Property{Name: "A", Latitude: 24.5, Longitude: 73.6, Rating: 6}, true},
+	}
+	for _, tt := range tests {
+		if err := validateProperty(tt.prop); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateProperty error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestStatsReportsSkippedProperties(t *testing.T) {
+	loaded, skipped, err := loadProperties(writeTempFile(t, "properties.json", mixedCatalog), "lenient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withCatalog(t, loaded)
+	setCatalog(loaded, skipped)
+
+	rec := httptest.NewRecorder()
+	statsHandler(rec, httptest.NewRequest("GET", "/stats", nil))
+	var stats statsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Properties != 2 || stats.SkippedProperties != 3 {
+		t.Errorf("stats report %d properties and %d skipped, want 2 and 3", stats.Properties, stats.SkippedProperties)
+	}
+}
+
+// TestSetCatalogResetsRankingsFirst holds the ranking memo while a catalog
+// is loaded: the new catalog must not be published until the memo of the
+// old one has been cleared.
+func TestSetCatalogResetsRankingsFirst(t *testing.T) {
+	withCatalog(t, []Property{{Name: "Old Stay", Latitude: 24.58, Longitude: 73.68}})
+	old := catalogVersion()
+
+	rankingMemoMu.Lock()
+	loaded := make(chan struct{})
+	go func() {
+		defer close(loaded)
+		setCatalog([]Property{{Name: "New Stay", Latitude: 24.58, Longitude: 73.68}}, 0)
+	}()
+	defer func() {
+		rankingMemoMu.Unlock()
+		<-loaded
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if !catalogMu.TryRLock() {
+			// setCatalog is waiting for the memo with the catalog locked.
+			return
+		}
+		published := catalogGeneration != old
+		catalogMu.RUnlock()
+		if published {
+			t.Fatal("the new catalog was published while the old rankings were still memoized")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("setCatalog neither published the catalog nor waited for the ranking memo")
+}
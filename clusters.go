@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var clusterDistanceKm = 10.0
+
+type propertyCluster struct {
+	Label      string      `json:"label"`
+	Centroid   Coordinates `json:"centroid"`
+	Properties []Property  `json:"properties"`
+}
+
+// clusterProperties groups properties that are within thresholdKm of each
+// other, directly or through a chain of neighbours.
+func clusterProperties(props []Property, thresholdKm float64) []propertyCluster {
+	parent := make([]int, len(props))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range props {
+		for j := i + 1; j < len(props); j++ {
+			if calculateDistance(props[i].Latitude, props[i].Longitude, props[j].Latitude, props[j].Longitude) <= thresholdKm {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+
+	groups := make(map[int][]Property)
+	var roots []int
+	for i, prop := range props {
+		root := find(i)
+		if _, exists := groups[root]; !exists {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], prop)
+	}
+
+	clusters := make([]propertyCluster, 0, len(roots))
+	for _, root := range roots {
+		members := groups[root]
+		var centroid Coordinates
+		for _, prop := range members {
+			centroid.Lat += prop.Latitude
+			centroid.Lon += prop.Longitude
+		}
+		centroid.Lat /= float64(len(members))
+		centroid.Lon /= float64(len(members))
+		clusters = append(clusters, propertyCluster{
+			Label:      clusterLabel(members, centroid),
+			Centroid:   centroid,
+			Properties: members,
+		})
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Label < clusters[j].Label
+	})
+	return clusters
+}
+
+// clusterLabel names a cluster after the words its members' names start
+// with, e.g. "Moustache Udaipur". When they only share the brand, or nothing
+// at all, the member closest to the centroid names the cluster instead.
+func clusterLabel(members []Property, centroid Coordinates) string {
+	common := strings.Fields(members[0].Name)
+	for _, prop := range members[1:] {
+		words := strings.Fields(prop.Name)
+		n := 0
+		for n < len(common) && n < len(words) && common[n] == words[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	if len(common) >= 2 {
+		return strings.Join(common, " ")
+	}
+
+	closest := members[0]
+	closestDistance := calculateDistance(centroid.Lat, centroid.Lon, closest.Latitude, closest.Longitude)
+	for _, prop := range members[1:] {
+		if d := calculateDistance(centroid.Lat, centroid.Lon, prop.Latitude, prop.Longitude); d < closestDistance {
+			closest, closestDistance = prop, d
+		}
+	}
+	return closest.Name
+}
+
+func groupedPropertiesHandler(w http.ResponseWriter, r *http.Request) {
+	threshold := clusterDistanceKm
+	if raw := r.URL.Query().Get("threshold_km"); raw != "" {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value < 0 {
+			http.Error(w, "Query parameter 'threshold_km' must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		threshold = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Clusters []propertyCluster `json:"clusters"`
+	}{clusterProperties(currentProperties(), threshold)})
+}
+
+// resultsCentroid averages the coordinates of the results within the
+// search radius, or returns nil when there are none.
+func resultsCentroid(results []PropertyResponse) *Coordinates {
+	var centroid Coordinates
+	matched := 0
+	for _, result := range results {
+		if !result.withinSearchRadius() {
+			continue
+		}
+		centroid.Lat += result.Latitude
+		centroid.Lon += result.Longitude
+		matched++
+	}
+	if matched == 0 {
+		return nil
+	}
+	centroid.Lat /= float64(matched)
+	centroid.Lon /= float64(matched)
+	return &centroid
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// coverageGap is a city center with no property within the coverage
+// radius. Nearest is empty when the catalog has no properties at all.
+type coverageGap struct {
+	City     string  `json:"city"`
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Nearest  string  `json:"nearest_property,omitempty"`
+	Distance float64 `json:"distance_km,omitempty"`
+}
+
+type coverageResponse struct {
+	RadiusKm  float64       `json:"radius_km"`
+	Cities    int           `json:"cities"`
+	Uncovered []coverageGap `json:"uncovered"`
+}
+
+// coverageGaps returns the cities with no property within radiusKm, the
+// farthest from any property first.
+func coverageGaps(cities map[string]Coordinates, props []Property, radiusKm float64) []coverageGap {
+	gaps := []coverageGap{}
+	for city, coords := range cities {
+		gap := coverageGap{City: city, Lat: coords.Lat, Lon: coords.Lon}
+		covered := false
+		for i, prop := range props {
+			distance := calculateDistance(coords.Lat, coords.Lon, prop.Latitude, prop.Longitude)
+			if i == 0 || distance < gap.Distance {
+				gap.Nearest, gap.Distance = prop.Name, distance
+			}
+			if distance <= radiusKm {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			gaps = append(gaps, gap)
+		}
+	}
+	sort.Slice(gaps, func(i, j int) bool {
+		if gaps[i].Distance != gaps[j].Distance {
+			return gaps[i].Distance > gaps[j].Distance
+		}
+		return gaps[i].City < gaps[j].City
+	})
+	return gaps
+}
+
+func coverageHandler(w http.ResponseWriter, r *http.Request) {
+	radius := defaultRadiusKm
+	if raw := r.URL.Query().Get("radius"); raw != "" {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value <= 0 || value > maxRadiusKm {
+			http.Error(w, fmt.Sprintf("Query parameter 'radius' must be a number above 0 and at most %g", maxRadiusKm), http.StatusBadRequest)
+			return
+		}
+		radius = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(coverageResponse{
+		RadiusKm:  radius,
+		Cities:    len(cityCenters),
+		Uncovered: coverageGaps(cityCenters, currentProperties(), radius),
+	})
+}
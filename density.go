@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// defaultDensityCellKm is the grid cell size /density uses by default.
+const defaultDensityCellKm = 25.0
+
+type densityCell struct {
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	Count int     `json:"count"`
+}
+
+type densityResponse struct {
+	CellKm float64       `json:"cell_km"`
+	Cells  []densityCell `json:"cells"`
+}
+
+type densityKey struct {
+	row, col int
+}
+
+// densityGrid bins props into cells roughly cellKm on a side and returns
+// the centers of the non-empty cells, densest first. Rows are bands of
+// equal latitude; each row's cells are widened in longitude by the row's
+// latitude so cells stay close to square on the ground.
+func densityGrid(props []Property, cellKm float64) []densityCell {
+	kmPerDegree := earthRadiusKm * math.Pi / 180
+	cellLat := cellKm / kmPerDegree
+	cellLon := func(row int) float64 {
+		center := (float64(row) + 0.5) * cellLat
+		return cellKm / (kmPerDegree * math.Max(math.Cos(toRadians(center)), 0.01))
+	}
+
+	counts := make(map[densityKey]int)
+	for _, prop := range props {
+		row := int(math.Floor(prop.Latitude / cellLat))
+		col := int(math.Floor(prop.Longitude / cellLon(row)))
+		counts[densityKey{row, col}]++
+	}
+
+	cells := make([]densityCell, 0, len(counts))
+	for key, count := range counts {
+		cells = append(cells, densityCell{
+			Lat:   (float64(key.row) + 0.5) * cellLat,
+			Lon:   (float64(key.col) + 0.5) * cellLon(key.row),
+			Count: count,
+		})
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Count != cells[j].Count {
+			return cells[i].Count > cells[j].Count
+		}
+		if cells[i].Lat != cells[j].Lat {
+			return cells[i].Lat < cells[j].Lat
+		}
+		return cells[i].Lon < cells[j].Lon
+	})
+	return cells
+}
+
+func densityHandler(w http.ResponseWriter, r *http.Request) {
+	cellKm := defaultDensityCellKm
+	if raw := r.URL.Query().Get("cell_km"); raw != "" {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value <= 0 || math.IsInf(value, 0) {
+			http.Error(w, "Query parameter 'cell_km' must be a positive number", http.StatusBadRequest)
+			return
+		}
+		cellKm = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(densityResponse{CellKm: cellKm, Cells: densityGrid(currentProperties(), cellKm)})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// earthRadiusKm is the sphere radius used by calculateDistance. It defaults
+// to the IUGG mean earth radius; systems that assume the equatorial radius
+// (6378.137km) report distances roughly 0.1% longer.
+var earthRadiusKm = 6371.0
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// calculateDistance returns the great-circle distance in kilometers between
+// two points using the Haversine formula.
+func calculateDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// distanceUnits maps the values of the unit query parameter to their size
+// in kilometers.
+var distanceUnits = map[string]float64{
+	"km": 1,
+	"mi": 1.609344,
+	"m":  0.001,
+}
+
+// maxDistancePrecision bounds the decimals a distance can be rounded to.
+const maxDistancePrecision = 6
+
+// unitPrecisions holds the default number of decimals distances in each of
+// distanceUnits are rounded to. It implements flag.Value as comma-separated
+// unit=decimals pairs, overriding only the units given.
+type unitPrecisions map[string]int
+
+var distancePrecision = unitPrecisions{"km": 2, "mi": 1, "m": 0}
+
+func (p unitPrecisions) String() string {
+	return fmt.Sprintf("km=%d,mi=%d,m=%d", p["km"], p["mi"], p["m"])
+}
+
+func (p unitPrecisions) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		unit, raw, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if _, known := distanceUnits[unit]; !ok || !known {
+			return fmt.Errorf("want unit=decimals pairs with a unit of km, mi or m, got %q", pair)
+		}
+		decimals, err := strconv.Atoi(raw)
+		if err != nil || decimals < 0 || decimals > maxDistancePrecision {
+			return fmt.Errorf("decimals for %s must be from 0 to %d", unit, maxDistancePrecision)
+		}
+		p[unit] = decimals
+	}
+	return nil
+}
+
+// convertDistance expresses km in unit, rounded to decimals places.
+func convertDistance(km float64, unit string, decimals int) float64 {
+	scale := math.Pow10(decimals)
+	return math.Round(km/distanceUnits[unit]*scale) / scale
+}
+
+// coordinatePrecision is how many decimals of a property's latitude and
+// longitude responses show; -1 shows them in full. Distances are always
+// measured from the exact coordinates.
+var coordinatePrecision = -1
+
+// maxCoordinatePrecision is the most decimals -coordinate-precision takes;
+// beyond it a float64 degree value has no more to give.
+const maxCoordinatePrecision = 15
+
+// truncateCoordinate cuts degrees down to coordinatePrecision decimals,
+// towards zero so that it never moves a listing past a grid line.
+func truncateCoordinate(degrees float64) float64 {
+	if coordinatePrecision < 0 {
+		return degrees
+	}
+	scale := math.Pow10(coordinatePrecision)
+	// Snap away float error first so that 27.29 does not become 27.28.
+	scaled := math.Round(degrees*scale*1e6) / 1e6
+	return math.Trunc(scaled) / scale
+}
+
+// exactCoordinates returns the catalog coordinates of results, which
+// unlike their own have not been cut down to coordinatePrecision, for
+// measuring from them. Repeated names are told apart by their truncated
+// coordinates, and a result no longer in the catalog keeps its own.
+func exactCoordinates(results []PropertyResponse) []Coordinates {
+	byName := make(map[string][]Property)
+	for _, prop := range currentProperties() {
+		byName[prop.Name] = append(byName[prop.Name], prop)
+	}
+	coords := make([]Coordinates, len(results))
+	for i, result := range results {
+		coords[i] = Coordinates{Lat: result.Latitude, Lon: result.Longitude}
+		for _, prop := range byName[result.Name] {
+			if truncateCoordinate(prop.Latitude) == result.Latitude && truncateCoordinate(prop.Longitude) == result.Longitude {
+				coords[i] = Coordinates{Lat: prop.Latitude, Lon: prop.Longitude}
+				break
+			}
+		}
+	}
+	return coords
+}
+
+// mapURLTemplate builds map_url: {lat}, {lon} and {zoom} are replaced by
+// the property's coordinates and mapZoom. Empty disables map URLs.
+var (
+	mapURLTemplate string
+	mapZoom        = 14
+)
+
+// mapURL returns the static map URL centered on lat, lon, or "" when no
+// template is configured.
+func mapURL(lat, lon float64) string {
+	if mapURLTemplate == "" {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{lat}", strconv.FormatFloat(lat, 'f', -1, 64),
+		"{lon}", strconv.FormatFloat(lon, 'f', -1, 64),
+		"{zoom}", strconv.Itoa(mapZoom),
+	).Replace(mapURLTemplate)
+}
+
+// initialBearing returns the compass direction, in degrees clockwise from
+// north in [0, 360), in which the great circle from the first point to the
+// second sets off.
+func initialBearing(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1, phi2 := toRadians(lat1), toRadians(lat2)
+	dLon := toRadians(lon2 - lon1)
+	y := math.Sin(dLon) * math.Cos(phi2)
+	x := math.Cos(phi1)*math.Sin(phi2) - math.Sin(phi1)*math.Cos(phi2)*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// bearingDifference is the smaller angle between two bearings, 0 to 180.
+func bearingDifference(a, b float64) float64 {
+	return math.Abs(math.Mod(a-b+540, 360) - 180)
+}
+
+type distanceFunc func(lat1, lon1, lat2, lon2 float64) float64
+
+// distanceFormulas are the values accepted by the formula query parameter.
+var distanceFormulas = map[string]distanceFunc{
+	"great-circle": calculateDistance,
+	"vincenty":     vincentyDistance,
+}
+
+// WGS-84 ellipsoid parameters used by vincentyDistance.
+const (
+	wgs84SemiMajorAxis = 6378137.0
+	wgs84Flattening    = 1 / 298.257223563
+	wgs84SemiMinorAxis = (1 - wgs84Flattening) * wgs84SemiMajorAxis
+)
+
+// vincentyDistance returns the ellipsoidal distance in kilometers between
+// two points using Vincenty's inverse formula. The iteration does not
+// converge for nearly antipodal points, in which case it falls back to the
+// great-circle distance.
+func vincentyDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	const (
+		maxIterations = 200
+		tolerance     = 1e-12
+	)
+
+	L := toRadians(lon2 - lon1)
+	U1 := math.Atan((1 - wgs84Flattening) * math.Tan(toRadians(lat1)))
+	U2 := math.Atan((1 - wgs84Flattening) * math.Tan(toRadians(lat2)))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	lambda := L
+	var sinSigma, cosSigma, sigma, cos2Alpha, cos2SigmaM float64
+	converged := false
+	for i := 0; i < maxIterations; i++ {
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma = math.Sqrt(math.Pow(cosU2*sinLambda, 2) + math.Pow(cosU1*sinU2-sinU1*cosU2*cosLambda, 2))
+		if sinSigma == 0 {
+			return 0
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cos2Alpha = 1 - sinAlpha*sinAlpha
+		cos2SigmaM = 0
+		if cos2Alpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cos2Alpha
+		}
+		C := wgs84Flattening / 16 * cos2Alpha * (4 + wgs84Flattening*(4-3*cos2Alpha))
+		previous := lambda
+		lambda = L + (1-C)*wgs84Flattening*sinAlpha*
+			(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-previous) < tolerance {
+			converged = true
+			break
+		}
+	}
+	if !converged {
+		return calculateDistance(lat1, lon1, lat2, lon2)
+	}
+
+	uSq := cos2Alpha * (wgs84SemiMajorAxis*wgs84SemiMajorAxis - wgs84SemiMinorAxis*wgs84SemiMinorAxis) / (wgs84SemiMinorAxis * wgs84SemiMinorAxis)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+		B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+	return wgs84SemiMinorAxis * A * (sigma - deltaSigma) / 1000
+}
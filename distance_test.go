@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestVincentyDistance(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		wantKm, toleranceKm    float64
+	}{
+		// Vincenty's own worked example, Flinders Peak to Buninyong.
+		{"flinders peak to buninyong", -37.95103342, 144.42486789, -37.65282114, 143.92649554, 54.972271, 1e-6},
+		{"udaipur to jaipur", 24.5854, 73.7125, 26.9124, 75.7873, 331.33, 0.01},
+		{"same point", 24.5854, 73.7125, 24.5854, 73.7125, 0, 0},
+	}
+	for _, tt := range tests {
+		got := vincentyDistance(tt.lat1, tt.lon1, tt.lat2, tt.lon2)
+		if math.Abs(got-tt.wantKm) > tt.toleranceKm {
+			t.Errorf("%s: vincentyDistance = %.6fkm, want %.6f±%g", tt.name, got, tt.wantKm, tt.toleranceKm)
+		}
+		// The ellipsoid and the mean sphere agree to within half a percent.
+		if sphere := calculateDistance(tt.lat1, tt.lon1, tt.lat2, tt.lon2); math.Abs(got-sphere) > 0.005*sphere {
+			t.Errorf("%s: vincenty %.3fkm and great-circle %.3fkm differ by more than 0.5%%", tt.name, got, sphere)
+		}
+	}
+}
+
+func TestVincentyDistanceAntipodalFallback(t *testing.T) {
+	// Vincenty's iteration does not converge for this nearly antipodal pair.
+	lat1, lon1, lat2, lon2 := 0.0, 0.0, 0.5, 179.7
+	if got, want := vincentyDistance(lat1, lon1, lat2, lon2), calculateDistance(lat1, lon1, lat2, lon2); got != want {
+		t.Errorf("vincentyDistance = %v, want the great-circle fallback %v", got, want)
+	}
+}
+
+func TestUnitPrecisionsSet(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    unitPrecisions
+		wantErr bool
+	}{
+		{"km=3", unitPrecisions{"km": 3, "mi": 1, "m": 0}, false},
+		{"mi=2, m=1", unitPrecisions{"km": 2, "mi": 2, "m": 1}, false},
+		{"km=7", nil, true},
+		{"km=-1", nil, true},
+		{"ft=1", nil, true},
+		{"km", nil, true},
+	}
+	for _, tt := range tests {
+		p := unitPrecisions{"km": 2, "mi": 1, "m": 0}
+		err := p.Set(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err == nil && p.String() != tt.want.String() {
+			t.Errorf("Set(%q) = %s, want %s", tt.value, p, tt.want)
+		}
+	}
+}
+
+func TestSearchDefaultPrecisionPerUnit(t *testing.T) {
+	// Lake Stay is 3.3406889km, or 2.0758mi, from the center of Udaipur.
+	withCatalog(t, []Property{{Name: "Lake Stay", Latitude: 24.58, Longitude: 73.68}})
+	tests := []struct {
+		rawQuery string
+		want     float64
+	}{
+		{"unit=km", 3.34},
+		{"unit=mi", 2.1},
+		{"unit=m", 3341},
+		{"unit=mi&precision=3", 2.076},
+		{"precision=4", 3.3407},
+	}
+	for _, tt := range tests {
+		withFreshCache(t)
+		rec, response := getSearch(t, "q=udaipur&"+tt.rawQuery)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.rawQuery, rec.Code, rec.Body.String())
+		}
+		if len(response.Properties) != 1 || response.Properties[0].UnitDistance == nil {
+			t.Fatalf("%s: got %+v, want Lake Stay with a converted distance", tt.rawQuery, response.Properties)
+		}
+		if got := *response.Properties[0].UnitDistance; got != tt.want {
+			t.Errorf("%s: distance %v, want %v", tt.rawQuery, got, tt.want)
+		}
+	}
+}
+
+// withCoordinatePrecision sets coordinatePrecision for the duration of a test.
+func withCoordinatePrecision(t *testing.T, decimals int) {
+	t.Helper()
+	previous := coordinatePrecision
+	coordinatePrecision = decimals
+	t.Cleanup(func() { coordinatePrecision = previous })
+}
+
+func TestTruncateCoordinate(t *testing.T) {
+	tests := []struct {
+		decimals int
+		degrees  float64
+		want     float64
+	}{
+		{-1, 24.585412, 24.585412},
+		{2, 24.585412, 24.58},
+		{2, 24.589999, 24.58},
+		{2, 27.29, 27.29},
+		{2, -73.7125, -73.71},
+		{0, 73.99, 73},
+		{3, 0.0005, 0},
+	}
+	for _, tt := range tests {
+		withCoordinatePrecision(t, tt.decimals)
+		if got := truncateCoordinate(tt.degrees); got != tt.want {
+			t.Errorf("truncateCoordinate(%v) at %d decimals = %v, want %v", tt.degrees, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestExactCoordinates(t *testing.T) {
+	withCatalog(t, []Property{
+		{Name: "Twin Stay", Latitude: 24.5812, Longitude: 73.6834},
+		{Name: "Twin Stay", Latitude: 26.9177, Longitude: 75.7899},
+	})
+	withCoordinatePrecision(t, 2)
+
+	results := []PropertyResponse{
+		{Name: "Twin Stay", Latitude: 26.91, Longitude: 75.78},
+		{Name: "Twin Stay", Latitude: 24.58, Longitude: 73.68},
+		{Name: "Gone Stay", Latitude: 15.49, Longitude: 73.82},
+	}
+	want := []Coordinates{{Lat: 26.9177, Lon: 75.7899}, {Lat: 24.5812, Lon: 73.6834}, {Lat: 15.49, Lon: 73.82}}
+	if got := exactCoordinates(results); !slices.Equal(got, want) {
+		t.Errorf("exactCoordinates = %v, want %v", got, want)
+	}
+}
+
+func TestSearchTruncatesCoordinates(t *testing.T) {
+	withCatalog(t, []Property{{Name: "Lake Stay", Latitude: 24.5812, Longitude: 73.6834}})
+	withCoordinatePrecision(t, 2)
+	withFreshCache(t)
+
+	center := cityCenters["udaipur"]
+	_, response := getSearch(t, "q=udaipur")
+	if len(response.Properties) != 1 {
+		t.Fatalf("got %v, want Lake Stay", resultNames(response))
+	}
+	got := response.Properties[0]
+	if got.Latitude != 24.58 || got.Longitude != 73.68 {
+		t.Errorf("coordinates %v, %v; want 24.58, 73.68", got.Latitude, got.Longitude)
+	}
+	if want := calculateDistance(center.Lat, center.Lon, 24.5812, 73.6834); got.Distance != want {
+		t.Errorf("distance %v, want %v measured from the exact coordinates", got.Distance, want)
+	}
+}
+
+func TestMatrixMeasuresExactCoordinates(t *testing.T) {
+	withCatalog(t, []Property{
+		{Name: "Lake Stay", Latitude: 24.5812, Longitude: 73.6834},
+		{Name: "Hill Stay", Latitude: 24.6099, Longitude: 73.7099},
+	})
+	withCoordinatePrecision(t, 2)
+	withFreshCache(t)
+
+	rec := httptest.NewRecorder()
+	matrixHandler(rec, httptest.NewRequest("GET", "/matrix?q=udaipur", nil))
+	var response matrixResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+	}
+	if len(response.Distances) != 2 {
+		t.Fatalf("got a %d-property matrix, want 2", len(response.Distances))
+	}
+	if want := calculateDistance(24.5812, 73.6834, 24.6099, 73.7099); response.Distances[0][1] != want {
+		t.Errorf("distance %v, want %v between the exact coordinates", response.Distances[0][1], want)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import "strings"
+
+// explainResponse describes how a search would run, for explain=true.
+type explainResponse struct {
+	Query  string         `json:"query,omitempty"`
+	Origin *explainOrigin `json:"origin,omitempty"`
+	// Anchors replaces Origin for near=, one per anchor property.
+	Anchors []explainOrigin `json:"anchors,omitempty"`
+	// Geocoder is set when the query would be sent to the geocoder before
+	// falling back to the fuzzy match shown in Origin.
+	Geocoder string  `json:"geocoder,omitempty"`
+	RadiusKm float64 `json:"radius_km"`
+	Unit     string  `json:"unit,omitempty"`
+	CacheHit bool    `json:"cache_hit"`
+	// RankingMemoized means distances from the origin are already known,
+	// so no properties would be examined.
+	RankingMemoized    bool `json:"ranking_memoized"`
+	PropertiesExamined int  `json:"properties_examined"`
+}
+
+type explainOrigin struct {
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	Label string  `json:"label"`
+	// Source is how the origin was found: pin, airport, city, property,
+	// fuzzy, near or default.
+	Source string `json:"source"`
+}
+
+// explainSearch reports what searchProperties would do for query and opts
+// without doing it: it neither calls the geocoder nor reads from or writes
+// to anything that records the lookup.
+func explainSearch(query string, opts searchOptions) explainResponse {
+	query = strings.TrimSpace(query)
+	response := explainResponse{Query: query, RadiusKm: opts.RadiusKm, Unit: opts.Unit}
+	if response.RadiusKm == 0 {
+		response.RadiusKm = defaultRadiusKm
+	}
+
+	var querySource string
+	origin, anchors, source, found := searchOrigin(opts, func() (resolvedOrigin, bool) {
+		origin, found := resolveExact(query)
+		switch {
+		case found && origin.FromProperty:
+			querySource = "property"
+		case found:
+			querySource = "city"
+		case !opts.ExactOnly:
+			if geocoder != nil {
+				response.Geocoder = "would be queried first"
+			}
+			origin, found = findBestMatch(query)
+			querySource = "fuzzy"
+		}
+		return origin, found
+	})
+	if source == "" {
+		source = querySource
+	}
+
+	cacheKey := searchCacheKey(NormalizeQuery(query), opts)
+	if found && origin.Fuzzy {
+		cacheKey = searchCacheKey(origin.Label, opts)
+	}
+	_, response.CacheHit = searchCache.Get(cacheKey)
+	if !found {
+		return response
+	}
+
+	if anchors != nil {
+		// Each anchor is ranked, or found memoized, on its own.
+		response.RankingMemoized = true
+		for _, anchor := range anchors {
+			response.Anchors = append(response.Anchors, explainOrigin{Lat: anchor.Lat, Lon: anchor.Lon, Label: anchor.Label, Source: "near"})
+			if !isRankingMemoized(anchor, opts.Formula) {
+				response.RankingMemoized = false
+				if !response.CacheHit {
+					response.PropertiesExamined += len(currentProperties())
+				}
+			}
+		}
+		return response
+	}
+
+	response.Origin = &explainOrigin{Lat: origin.Lat, Lon: origin.Lon, Label: origin.Label, Source: source}
+	response.RankingMemoized = isRankingMemoized(origin, opts.Formula)
+	if !response.CacheHit && !response.RankingMemoized {
+		response.PropertiesExamined = len(currentProperties())
+	}
+	return response
+}
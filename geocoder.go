@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Geocoder resolves place names that are not in cityCenters.
+type Geocoder interface {
+	Geocode(ctx context.Context, query string) (Coordinates, error)
+}
+
+// geocoder is nil unless -geocoder-url is configured.
+var geocoder Geocoder
+
+var errPlaceNotFound = errors.New("place not found")
+
+// httpGeocoder calls a JSON endpoint built from a URL template in which {q}
+// is replaced by the escaped query. The endpoint answers {"lat":..,"lon":..}
+// or 404 for unknown places.
+type httpGeocoder struct {
+	urlTemplate string
+	client      *http.Client
+}
+
+func newHTTPGeocoder(urlTemplate string) *httpGeocoder {
+	return &httpGeocoder{urlTemplate: urlTemplate, client: &http.Client{}}
+}
+
+func (g *httpGeocoder) Geocode(ctx context.Context, query string) (Coordinates, error) {
+	target := strings.ReplaceAll(g.urlTemplate, "{q}", url.QueryEscape(query))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return Coordinates{}, err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return Coordinates{}, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return Coordinates{}, errPlaceNotFound
+	case resp.StatusCode != http.StatusOK:
+		return Coordinates{}, fmt.Errorf("geocoder returned %s", resp.Status)
+	}
+	var coords Coordinates
+	if err := json.NewDecoder(resp.Body).Decode(&coords); err != nil {
+		return Coordinates{}, fmt.Errorf("decoding geocoder response: %w", err)
+	}
+	return coords, nil
+}
+
+// geocodeBackoff controls how long a query that failed to geocode is
+// skipped. The wait doubles with each consecutive failure up to Max.
+var geocodeBackoff = struct {
+	Initial time.Duration
+	Max     time.Duration
+}{Initial: 30 * time.Second, Max: time.Hour}
+
+type geocodeFailure struct {
+	failures int
+	retryAt  time.Time
+}
+
+// geocodeFailures is keyed by client queries, so it is capped at
+// maxGeocodeFailures entries and forgets a failure once its backoff has
+// been over for geocodeBackoff.Max, long enough that it no longer counts
+// as consecutive.
+var (
+	geocodeFailures   = make(map[string]geocodeFailure)
+	geocodeFailuresMu sync.Mutex
+)
+
+const maxGeocodeFailures = 10000
+
+// forgotten reports whether the failure is stale enough to be dropped.
+func (f geocodeFailure) forgotten(now time.Time) bool {
+	return now.Sub(f.retryAt) > geocodeBackoff.Max
+}
+
+// rememberGeocodeFailure stores failure under key. When the map is full it
+// first drops forgotten failures and then, if that was not enough, the one
+// whose backoff ended first. Callers hold geocodeFailuresMu.
+func rememberGeocodeFailure(key string, failure geocodeFailure) {
+	if _, exists := geocodeFailures[key]; !exists && len(geocodeFailures) >= maxGeocodeFailures {
+		now := time.Now()
+		var oldest string
+		for k, f := range geocodeFailures {
+			if f.forgotten(now) {
+				delete(geocodeFailures, k)
+			} else if oldest == "" || f.retryAt.Before(geocodeFailures[oldest].retryAt) {
+				oldest = k
+			}
+		}
+		if len(geocodeFailures) >= maxGeocodeFailures {
+			delete(geocodeFailures, oldest)
+		}
+	}
+	geocodeFailures[key] = failure
+}
+
+// geocode asks the configured geocoder for query unless a recent failure
+// for the same query is still backing off.
+func geocode(ctx context.Context, query string) (Coordinates, bool) {
+	if geocoder == nil {
+		return Coordinates{}, false
+	}
+
+	key := NormalizeQuery(query)
+	geocodeFailuresMu.Lock()
+	failure, failed := geocodeFailures[key]
+	if failed && failure.forgotten(time.Now()) {
+		delete(geocodeFailures, key)
+		failure, failed = geocodeFailure{}, false
+	}
+	geocodeFailuresMu.Unlock()
+	if failed && time.Now().Before(failure.retryAt) {
+		return Coordinates{}, false
+	}
+	if !geocodeBreaker.allow() {
+		return Coordinates{}, false
+	}
+
+	coords, err := geocodeWithRetry(ctx, query)
+	// An unknown place is a healthy answer as far as the breaker is concerned.
+	geocodeBreaker.record(err == nil || errors.Is(err, errPlaceNotFound))
+	geocodeFailuresMu.Lock()
+	defer geocodeFailuresMu.Unlock()
+	if err != nil {
+		failure.failures++
+		failure.retryAt = time.Now().Add(backoffDelay(failure.failures))
+		rememberGeocodeFailure(key, failure)
+		log.Printf("Geocoding '%s' failed (%d in a row, retrying after %s): %v", query, failure.failures, failure.retryAt.Format(time.RFC3339), err)
+		return Coordinates{}, false
+	}
+	delete(geocodeFailures, key)
+	return coords, true
+}
+
+// geocodeRetry bounds each geocoder call by Timeout and retries failed
+// calls up to Retries times, waiting a random duration of up to Backoff,
+// doubled per attempt, in between. Unknown places are not retried.
+var geocodeRetry = struct {
+	Timeout time.Duration
+	Retries int
+	Backoff time.Duration
+}{Timeout: 500 * time.Millisecond, Retries: 2, Backoff: 100 * time.Millisecond}
+
+func geocodeWithRetry(ctx context.Context, query string) (Coordinates, error) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var coords Coordinates
+		attemptCtx, cancel := context.WithTimeout(ctx, geocodeRetry.Timeout)
+		coords, err = geocoder.Geocode(attemptCtx, query)
+		cancel()
+		if err == nil || errors.Is(err, errPlaceNotFound) || attempt == geocodeRetry.Retries {
+			return coords, err
+		}
+
+		wait := time.Duration(rand.Int64N(int64(geocodeRetry.Backoff<<attempt) + 1))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return Coordinates{}, err
+		}
+	}
+}
+
+func backoffDelay(failures int) time.Duration {
+	delay := geocodeBackoff.Initial
+	for i := 1; i < failures && delay < geocodeBackoff.Max; i++ {
+		delay *= 2
+	}
+	return min(delay, geocodeBackoff.Max)
+}
+
+// circuitBreaker stops calling a failing upstream. After Threshold
+// consecutive failures it opens and rejects calls for Cooldown, then lets a
+// single probe through (half-open): success closes it, failure reopens it.
+type circuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+var geocodeBreaker = &circuitBreaker{Threshold: 5, Cooldown: 30 * time.Second}
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+func (b *circuitBreaker) stateLocked() string {
+	switch {
+	case b.failures < b.Threshold:
+		return breakerClosed
+	case time.Since(b.openedAt) < b.Cooldown:
+		return breakerOpen
+	default:
+		return breakerHalfOpen
+	}
+}
+
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stateLocked()
+}
+
+// allow reports whether a call may go ahead. Every allowed call must be
+// followed by record.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.stateLocked() {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return false
+}
+
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if success {
+		if b.failures >= b.Threshold {
+			log.Printf("Geocoder recovered, closing circuit breaker")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.Threshold {
+		if b.failures == b.Threshold {
+			log.Printf("Geocoder failed %d times in a row, opening circuit breaker for %s", b.failures, b.Cooldown)
+		}
+		b.openedAt = time.Now()
+	}
+}
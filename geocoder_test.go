@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stubGeocoder fails its first failures calls with err and then answers
+// coords. With a delay it waits that long, or until its context ends.
+type stubGeocoder struct {
+	failures int
+	err      error
+	delay    time.Duration
+	coords   Coordinates
+	calls    int
+}
+
+func (g *stubGeocoder) Geocode(ctx context.Context, query string) (Coordinates, error) {
+	g.calls++
+	if g.delay > 0 {
+		select {
+		case <-time.After(g.delay):
+		case <-ctx.Done():
+			return Coordinates{}, ctx.Err()
+		}
+	}
+	if g.calls <= g.failures {
+		return Coordinates{}, g.err
+	}
+	return g.coords, nil
+}
+
+// withGeocoder installs g with fast retries for the duration of a test.
+func withGeocoder(t *testing.T, g Geocoder) {
+	t.Helper()
+	previous, previousRetry := geocoder, geocodeRetry
+	geocoder = g
+	geocodeRetry.Timeout = 20 * time.Millisecond
+	geocodeRetry.Backoff = time.Millisecond
+	t.Cleanup(func() { geocoder, geocodeRetry = previous, previousRetry })
+}
+
+func TestGeocodeWithRetry(t *testing.T) {
+	errUpstream := errors.New("upstream unavailable")
+	coords := Coordinates{Lat: 15.49, Lon: 73.82}
+	tests := []struct {
+		name      string
+		stub      stubGeocoder
+		wantErr   error
+		wantCalls int
+	}{
+		{"first call succeeds", stubGeocoder{}, nil, 1},
+		{"succeeds on a retry", stubGeocoder{failures: 2, err: errUpstream}, nil, 3},
+		{"retries exhausted", stubGeocoder{failures: 5, err: errUpstream}, errUpstream, 3},
+		{"unknown place is not retried", stubGeocoder{failures: 5, err: errPlaceNotFound}, errPlaceNotFound, 1},
+		{"each call times out", stubGeocoder{delay: time.Second}, context.DeadlineExceeded, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := tt.stub
+			stub.coords = coords
+			withGeocoder(t, &stub)
+
+			got, err := geocodeWithRetry(context.Background(), "panjim")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != coords {
+				t.Errorf("got %v, want %v", got, coords)
+			}
+			if stub.calls != tt.wantCalls {
+				t.Errorf("geocoder called %d times, want %d", stub.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestGeocodeWithRetryStopsWhenCanceled(t *testing.T) {
+	stub := &stubGeocoder{failures: 5, err: errors.New("upstream unavailable")}
+	withGeocoder(t, stub)
+	geocodeRetry.Backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := geocodeWithRetry(ctx, "panjim"); err == nil {
+		t.Fatal("geocodeWithRetry succeeded against a failing geocoder")
+	}
+	if stub.calls >= 1+geocodeRetry.Retries {
+		t.Errorf("geocoder called %d times after the request was canceled", stub.calls)
+	}
+}
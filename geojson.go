@@ -0,0 +1,41 @@
+package main
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONPoint      `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+// geoJSONPoint holds its position as [longitude, latitude] per RFC 7946.
+type geoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type geoJSONProperties struct {
+	Name     string  `json:"name"`
+	Distance float64 `json:"distance_km"`
+}
+
+func toGeoJSON(results []PropertyResponse) geoJSONFeatureCollection {
+	collection := geoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]geoJSONFeature, 0, len(results)),
+	}
+	for _, result := range results {
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type: "Feature",
+			Geometry: geoJSONPoint{
+				Type:        "Point",
+				Coordinates: [2]float64{result.Longitude, result.Latitude},
+			},
+			Properties: geoJSONProperties{Name: result.Name, Distance: result.Distance},
+		})
+	}
+	return collection
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSearchGeoJSON(t *testing.T) {
+	withFreshCache(t)
+	withCatalog(t, []Property{
+		{Name: "Lake Stay", Latitude: 24.58, Longitude: 73.68},
+		{Name: "Hill Stay", Latitude: 24.60, Longitude: 73.70},
+		{Name: "Far Stay", Latitude: 28.61, Longitude: 77.28},
+	})
+
+	rec := httptest.NewRecorder()
+	searchHandler(rec, httptest.NewRequest("GET", "/search?q=udaipur&format=geojson", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/geo+json" {
+		t.Errorf("Content-Type %q, want application/geo+json", contentType)
+	}
+
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string `json:"type"`
+			Geometry struct {
+				Type        string    `json:"type"`
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &collection); err != nil {
+		t.Fatal(err)
+	}
+	if collection.Type != "FeatureCollection" {
+		t.Errorf("type %q, want FeatureCollection", collection.Type)
+	}
+	want := map[string][2]float64{
+		"Lake Stay": {73.68, 24.58},
+		"Hill Stay": {73.70, 24.60},
+	}
+	if len(collection.Features) != len(want) {
+		t.Fatalf("%d features, want %d", len(collection.Features), len(want))
+	}
+	for _, feature := range collection.Features {
+		name, _ := feature.Properties["name"].(string)
+		if feature.Type != "Feature" || feature.Geometry.Type != "Point" {
+			t.Errorf("%q is a %s with a %s geometry, want a Feature with a Point", name, feature.Type, feature.Geometry.Type)
+		}
+		coords, exists := want[name]
+		if !exists {
+			t.Errorf("unexpected feature %q", name)
+			continue
+		}
+		if len(feature.Geometry.Coordinates) != 2 || feature.Geometry.Coordinates[0] != coords[0] || feature.Geometry.Coordinates[1] != coords[1] {
+			t.Errorf("%q at %v, want [longitude, latitude] %v", name, feature.Geometry.Coordinates, coords)
+		}
+		if _, ok := feature.Properties["distance_km"].(float64); !ok {
+			t.Errorf("%q has no numeric distance_km: %v", name, feature.Properties)
+		}
+	}
+}
@@ -3,11 +3,14 @@ module moustache-escapes
 go 1.24.2
 
 require (
-	github.com/agnivade/levenshtein v1.2.1 // indirect
-	github.com/erikstmartin/go-testdb v0.0.0-20160219214506-8d10e4a1bae5 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/kellydunn/golang-geo v0.7.0 // indirect
-	github.com/kylelemons/go-gypsy v1.0.0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
-	github.com/ziutek/mymysql v1.5.4 // indirect
+	github.com/agnivade/levenshtein v1.2.1
+	github.com/gorilla/mux v1.8.1
+	github.com/redis/go-redis/v9 v9.22.0
+	golang.org/x/sync v0.19.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	go.uber.org/atomic v1.11.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
 )
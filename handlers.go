@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func closestHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
+		return
+	}
+
+	origin, found := resolveOrigin(r.Context(), query, exactOnlyMatching)
+	if !found {
+		http.Error(w, "Location not recognized", http.StatusBadRequest)
+		return
+	}
+	ranked, _ := rankProperties(r.Context(), origin.Lat, origin.Lon, calculateDistance)
+	if len(ranked) == 0 {
+		http.Error(w, "No properties available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ranked[0].response())
+}
+
+// parseCoordinates reads the lat and lon query parameters.
+func parseCoordinates(r *http.Request) (Coordinates, error) {
+	lat, err := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return Coordinates{}, errors.New("query parameter 'lat' must be a latitude between -90 and 90")
+	}
+	lon, err := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return Coordinates{}, errors.New("query parameter 'lon' must be a longitude between -180 and 180")
+	}
+	return Coordinates{Lat: lat, Lon: lon}, nil
+}
+
+type nearestCity struct {
+	City     string  `json:"city"`
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Distance float64 `json:"distance_km"`
+}
+
+// findNearestCity returns the cityCenters entry closest to point, breaking
+// ties by name.
+func findNearestCity(point Coordinates) (nearestCity, bool) {
+	var nearest nearestCity
+	found := false
+	for city, coords := range cityCenters {
+		distance := calculateDistance(point.Lat, point.Lon, coords.Lat, coords.Lon)
+		if !found || distance < nearest.Distance || (distance == nearest.Distance && city < nearest.City) {
+			nearest = nearestCity{City: city, Lat: coords.Lat, Lon: coords.Lon, Distance: distance}
+			found = true
+		}
+	}
+	return nearest, found
+}
+
+func reverseHandler(w http.ResponseWriter, r *http.Request) {
+	point, err := parseCoordinates(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nearest, found := findNearestCity(point)
+	if !found {
+		http.Error(w, "No cities available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nearest)
+}
+
+// maxMatrixSize bounds N for /matrix, whose response grows with N squared.
+var maxMatrixSize = 25
+
+type matrixResponse struct {
+	Properties []string    `json:"properties"`
+	Distances  [][]float64 `json:"distances_km"`
+	Message    string      `json:"message,omitempty"`
+}
+
+func matrixHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), searchBudget)
+	defer cancel()
+	lang := negotiateLanguage(r.Header.Get("Accept-Language"))
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	search := searchProperties(ctx, query, searchOptions{ExactOnly: exactOnlyMatching, Lang: lang})
+	if len(search.Properties) > maxMatrixSize {
+		http.Error(w, fmt.Sprintf("Search matched %d properties, the matrix is limited to %d", len(search.Properties), maxMatrixSize), http.StatusBadRequest)
+		return
+	}
+
+	response := matrixResponse{
+		Properties: make([]string, len(search.Properties)),
+		Distances:  make([][]float64, len(search.Properties)),
+		Message:    search.Message,
+	}
+	coords := exactCoordinates(search.Properties)
+	for i, from := range coords {
+		response.Properties[i] = search.Properties[i].Name
+		response.Distances[i] = make([]float64, len(coords))
+		for j, to := range coords {
+			if i != j {
+				response.Distances[i][j] = calculateDistance(from.Lat, from.Lon, to.Lat, to.Lon)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	response := healthResponse{Status: "ok"}
+	status := http.StatusOK
+	deep := false
+	if raw := r.URL.Query().Get("deep"); raw != "" {
+		var err error
+		if deep, err = strconv.ParseBool(raw); err != nil {
+			http.Error(w, "Query parameter 'deep' must be a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+	if deep {
+		if err := checkSearchHealth(r); err != nil {
+			response = healthResponse{Status: "unhealthy", Reason: err.Error()}
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// The deep health check searches healthCheckQuery and expects
+// healthCheckExpected among the results, at most healthCheckMaxKm from it.
+// The defaults suit the built-in catalog; deployments with their own set
+// them with -health-query, -health-expect and -health-max-km.
+var (
+	healthCheckQuery    = "udaipur"
+	healthCheckExpected = "Moustache Udaipur"
+	healthCheckMaxKm    = 5.0
+)
+
+// checkSearchHealth runs healthCheckQuery through searchProperties, as a
+// client search would, and verifies the result looks sane: sorted by
+// distance and holding healthCheckExpected close enough to the query.
+func checkSearchHealth(r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), searchBudget)
+	defer cancel()
+	response := searchProperties(ctx, healthCheckQuery, searchOptions{ExactOnly: true})
+	switch {
+	case response.Unrecognized:
+		return fmt.Errorf("%q is not a known location", healthCheckQuery)
+	case response.Truncated:
+		return fmt.Errorf("search for %q did not complete", healthCheckQuery)
+	}
+
+	var expected *PropertyResponse
+	for i, result := range response.Properties {
+		if math.IsNaN(result.Distance) || result.Distance < 0 {
+			return fmt.Errorf("invalid distance %v for %q", result.Distance, result.Name)
+		}
+		if i > 0 && result.Distance < response.Properties[i-1].Distance {
+			return fmt.Errorf("results for %q are not sorted by distance", healthCheckQuery)
+		}
+		if result.Name == healthCheckExpected {
+			expected = &response.Properties[i]
+		}
+	}
+	switch {
+	case expected == nil:
+		return fmt.Errorf("expected %q in the results for %q", healthCheckExpected, healthCheckQuery)
+	case expected.Distance > healthCheckMaxKm:
+		return fmt.Errorf("%q is %.1fkm from %q, expected at most %gkm", healthCheckExpected, expected.Distance, healthCheckQuery, healthCheckMaxKm)
+	}
+	return nil
+}
+
+type statsResponse struct {
+	Properties        int   `json:"properties"`
+	SkippedProperties int   `json:"skipped_properties"`
+	CacheHits         int64 `json:"cache_hits"`
+	CacheMisses       int64 `json:"cache_misses"`
+	// CacheHitRatio covers the last CacheWindow and is omitted when there
+	// were no searches in it.
+	CacheHitRatio *float64 `json:"cache_hit_ratio,omitempty"`
+	CacheWindow   string   `json:"cache_window"`
+	// GeocoderBreaker is the geocoder circuit breaker state, when a
+	// geocoder is configured.
+	GeocoderBreaker string `json:"geocoder_breaker,omitempty"`
+	// CacheInsertsPaused is set while the cache insertion guard is
+	// refusing new entries.
+	CacheInsertsPaused bool  `json:"cache_inserts_paused,omitempty"`
+	Searches           int64 `json:"searches"`
+	// PlaceSearches counts searches by city. Searches for anything else
+	// are counted under otherPlaces, since raw queries may identify people;
+	// /admin/searches has them.
+	PlaceSearches map[string]int64 `json:"place_searches"`
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	catalogMu.RLock()
+	response := statsResponse{
+		Properties:        len(properties),
+		SkippedProperties: skippedProperties,
+	}
+	catalogMu.RUnlock()
+
+	metrics := searchCacheMetrics.snapshot()
+	response.CacheHits = metrics.Hits
+	response.CacheMisses = metrics.Misses
+	response.CacheWindow = hitRatioWindow.String()
+	if !math.IsNaN(metrics.WindowRatio) {
+		response.CacheHitRatio = &metrics.WindowRatio
+	}
+	if geocoder != nil {
+		response.GeocoderBreaker = geocodeBreaker.State()
+	}
+	response.CacheInsertsPaused = searchCacheGuard.Paused()
+	var byPlace map[string]int64
+	response.Searches, byPlace = searchCounts.snapshot()
+	response.PlaceSearches = make(map[string]int64)
+	for place, count := range byPlace {
+		if _, exists := cityCenters[place]; !exists {
+			place = otherPlaces
+		}
+		response.PlaceSearches[place] += count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type validateResponse struct {
+	Valid   bool             `json:"valid"`
+	Reason  string           `json:"reason,omitempty"`
+	Nearest *validateNearest `json:"nearest,omitempty"`
+}
+
+type validateNearest struct {
+	City     *nearestCity      `json:"city,omitempty"`
+	Property *PropertyResponse `json:"property,omitempty"`
+}
+
+// validateHandler reports whether lat and lon are well-formed and inside
+// plausibleBounds. Invalid input is described in the response rather than
+// rejected, since checking it is the point of the endpoint.
+func validateHandler(w http.ResponseWriter, r *http.Request) {
+	var response validateResponse
+	point, err := parseCoordinates(r)
+	switch {
+	case err != nil:
+		response.Reason = err.Error()
+	case !plausibleBounds.contains(point.Lat, point.Lon):
+		response.Reason = fmt.Sprintf("coordinates are outside the served area %s", plausibleBounds.String())
+	default:
+		response.Valid = true
+	}
+
+	if err == nil {
+		response.Nearest = &validateNearest{}
+		if city, found := findNearestCity(point); found {
+			response.Nearest.City = &city
+		}
+		if ranked, _ := rankProperties(r.Context(), point.Lat, point.Lon, calculateDistance); len(ranked) > 0 {
+			property := ranked[0].response()
+			response.Nearest.Property = &property
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type suggestedRadius struct {
+	Count    int     `json:"count"`
+	Radius   float64 `json:"radius"`
+	Unit     string  `json:"unit"`
+	Farthest string  `json:"farthest"`
+}
+
+// suggestRadiusHandler answers the smallest radius a search from q needs to
+// include at least count properties: the distance to the count-th nearest,
+// rounded up so that searching with it keeps that property in range.
+func suggestRadiusHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := strings.TrimSpace(params.Get("q"))
+	if query == "" {
+		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
+		return
+	}
+	count := 1
+	if raw := params.Get("count"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 {
+			http.Error(w, "Query parameter 'count' must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		count = value
+	}
+	unit := params.Get("unit")
+	if unit == "" {
+		unit = "km"
+	}
+	if _, exists := distanceUnits[unit]; !exists {
+		http.Error(w, "Query parameter 'unit' must be km, mi or m", http.StatusBadRequest)
+		return
+	}
+
+	origin, found := resolveOrigin(r.Context(), query, exactOnlyMatching)
+	if !found {
+		http.Error(w, "Location not recognized", http.StatusBadRequest)
+		return
+	}
+	ranked, _ := memoizedRanking(r.Context(), origin, "")
+	if len(ranked) < count {
+		http.Error(w, fmt.Sprintf("Only %d properties available", len(ranked)), http.StatusNotFound)
+		return
+	}
+
+	nth := ranked[count-1]
+	scale := math.Pow10(distancePrecision[unit])
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestedRadius{
+		Count:    count,
+		Radius:   math.Ceil(nth.Distance/distanceUnits[unit]*scale) / scale,
+		Unit:     unit,
+		Farthest: nth.Name,
+	})
+}
+
+type analyzeResponse struct {
+	Label      string             `json:"label"`
+	Origin     Coordinates        `json:"origin"`
+	Properties []PropertyResponse `json:"properties"`
+}
+
+// analyzeHandler lists every property with its distance from q, nearest
+// first. It is meant for analytics, so unlike /search it has no radius,
+// filters or result cap.
+func analyzeHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
+		return
+	}
+	origin, found := resolveOrigin(r.Context(), query, exactOnlyMatching)
+	if !found {
+		http.Error(w, "Location not recognized", http.StatusBadRequest)
+		return
+	}
+
+	ranked, truncated := rankProperties(r.Context(), origin.Lat, origin.Lon, calculateDistance)
+	if truncated {
+		http.Error(w, "Analysis was interrupted", http.StatusServiceUnavailable)
+		return
+	}
+	response := analyzeResponse{
+		Label:      origin.Label,
+		Origin:     Coordinates{Lat: origin.Lat, Lon: origin.Lon},
+		Properties: make([]PropertyResponse, len(ranked)),
+	}
+	for i, result := range ranked {
+		response.Properties[i] = result.response()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// luckyHandler answers one property picked at random from those a search
+// with the same parameters finds within its radius. A seed makes the pick
+// reproducible for the same results.
+func luckyHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query, opts, err := parseSearchParams(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pick := rand.IntN
+	if raw := params.Get("seed"); raw != "" {
+		seed, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			http.Error(w, "Query parameter 'seed' must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		pick = rand.New(rand.NewPCG(seed, seed)).IntN
+	}
+	opts.Lang = negotiateLanguage(r.Header.Get("Accept-Language"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), searchBudget)
+	defer cancel()
+	response := searchProperties(ctx, query, opts)
+	if response.Unrecognized {
+		http.Error(w, response.Message, http.StatusBadRequest)
+		return
+	}
+	var candidates []PropertyResponse
+	for _, property := range response.Properties {
+		if property.withinSearchRadius() {
+			candidates = append(candidates, property)
+		}
+	}
+	if len(candidates) == 0 {
+		http.Error(w, response.Message, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Language", opts.Lang)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(candidates[pick(len(candidates))])
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+type hullResponse struct {
+	// Properties is the number of properties the hull encloses.
+	Properties int `json:"properties"`
+	// Hull is the boundary, counter-clockwise from the westernmost point.
+	// It is not closed: the last point does not repeat the first.
+	Hull []Coordinates `json:"hull"`
+}
+
+// convexHull returns the convex hull of points by Andrew's monotone chain,
+// treating longitude as x and latitude as y. Collinear boundary points are
+// left out, and fewer than three distinct points are returned as they are.
+func convexHull(points []Coordinates) []Coordinates {
+	sorted := make([]Coordinates, len(points))
+	copy(sorted, points)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Lon != sorted[j].Lon {
+			return sorted[i].Lon < sorted[j].Lon
+		}
+		return sorted[i].Lat < sorted[j].Lat
+	})
+	unique := sorted[:0]
+	for i, point := range sorted {
+		if i == 0 || point != sorted[i-1] {
+			unique = append(unique, point)
+		}
+	}
+	if len(unique) < 3 {
+		return unique
+	}
+
+	// cross is positive when o, a, b turn counter-clockwise.
+	cross := func(o, a, b Coordinates) float64 {
+		return (a.Lon-o.Lon)*(b.Lat-o.Lat) - (a.Lat-o.Lat)*(b.Lon-o.Lon)
+	}
+	hull := make([]Coordinates, 0, 2*len(unique))
+	for _, point := range unique {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], point) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, point)
+	}
+	lower := len(hull) + 1
+	for i := len(unique) - 2; i >= 0; i-- {
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], unique[i]) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, unique[i])
+	}
+	return hull[:len(hull)-1]
+}
+
+func hullHandler(w http.ResponseWriter, r *http.Request) {
+	props := currentProperties()
+	points := make([]Coordinates, len(props))
+	for i, prop := range props {
+		points[i] = Coordinates{Lat: prop.Latitude, Lon: prop.Longitude}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hullResponse{Properties: len(props), Hull: convexHull(points)})
+}
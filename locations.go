@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pinCodes maps Indian postal codes to the approximate center of the area
+// they serve. The bundled table covers the towns we have properties in and
+// can be replaced with -pin-file.
+var pinCodes = map[string]Coordinates{
+	"313001": {24.5854, 73.7125},
+	"302001": {26.9124, 75.7873},
+	"345001": {26.9157, 70.9083},
+	"342001": {26.2389, 73.0243},
+	"282001": {27.1767, 78.0081},
+	"110001": {28.6315, 77.2167},
+	"249201": {30.0869, 78.2676},
+	"221001": {25.3176, 82.9739},
+	"403001": {15.4909, 73.8278},
+	"396210": {20.3974, 72.8328},
+	"305022": {26.4897, 74.5511},
+	"471606": {24.8318, 79.9199},
+	"175131": {32.2396, 77.1887},
+	"263136": {29.3447, 79.5530},
+	"190001": {34.0837, 74.7973},
+	"322001": {26.0173, 76.3560},
+	"641001": {11.0168, 76.9558},
+}
+
+// airportCodes maps IATA codes to airport coordinates. The bundled table
+// covers the airports nearest our properties and can be replaced with
+// -airports-file.
+var airportCodes = map[string]Coordinates{
+	"UDR": {24.6177, 73.8961},
+	"JAI": {26.8242, 75.8122},
+	"JSA": {26.8887, 70.8650},
+	"JDH": {26.2511, 73.0489},
+	"AGR": {27.1558, 77.9609},
+	"DEL": {28.5562, 77.1000},
+	"DED": {30.1897, 78.1803},
+	"VNS": {25.4524, 82.8593},
+	"GOI": {15.3808, 73.8314},
+	"GOX": {15.7443, 73.8607},
+	"NMB": {20.4344, 72.8432},
+	"HJR": {24.8172, 79.9186},
+	"KUU": {31.8767, 77.1544},
+	"PGH": {29.0334, 79.4737},
+	"SXR": {33.9871, 74.7742},
+	"CJB": {11.0300, 77.0434},
+}
+
+// cityAliases maps alternate names for a city to its cityCenters key. Known
+// aliases resolve exactly, which fuzzy matching cannot do for names that
+// share no spelling with the canonical one.
+var cityAliases = map[string]string{
+	"delhi":         "delih",
+	"new delhi":     "delih",
+	"pink city":     "jaipur",
+	"city of lakes": "udaipur",
+	"golden city":   "jaisalmer",
+}
+
+func resolveAlias(city string) string {
+	if canonical, exists := cityAliases[city]; exists {
+		return canonical
+	}
+	return city
+}
+
+// loadAliases reads a JSON object of alias to city key. Aliases whose target
+// is not a known city are dropped with a warning.
+func loadAliases(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	aliases := make(map[string]string, len(raw))
+	for alias, city := range raw {
+		city = NormalizeQuery(city)
+		if _, exists := cityCenters[city]; !exists {
+			log.Printf("Ignoring alias %q: unknown city %q", alias, city)
+			continue
+		}
+		aliases[NormalizeQuery(alias)] = city
+	}
+	return aliases, nil
+}
+
+// namedLocation is the on-disk format of a location table entry.
+type namedLocation struct {
+	Name string  `json:"name"`
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+}
+
+// strictLocations makes a location file that gives one name two different
+// coordinates, or has an entry without a name or with coordinates off the
+// globe, fail to load. Otherwise the last of conflicting entries wins and
+// invalid ones are skipped, each with a warning.
+var strictLocations bool
+
+// loadLocationTable reads a JSON array of named coordinates, keyed by each
+// name passed through key. Invalid entries and names that collide under key
+// are handled as strictLocations says; exact repeats are only warned about.
+func loadLocationTable(path string, key func(string) string) (map[string]Coordinates, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []namedLocation
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	table := make(map[string]Coordinates, len(entries))
+	for i, entry := range entries {
+		name := key(entry.Name)
+		coords := Coordinates{Lat: entry.Lat, Lon: entry.Lon}
+		if problem := invalidLocation(name, coords); problem != "" {
+			if strictLocations {
+				return nil, fmt.Errorf("%s: entry %d %s", path, i, problem)
+			}
+			log.Printf("Warning: skipping %s entry %d, which %s", path, i, problem)
+			continue
+		}
+		if previous, exists := table[name]; exists {
+			switch {
+			case previous == coords:
+				log.Printf("Warning: %s entry %d repeats %q", path, i, entry.Name)
+			case strictLocations:
+				return nil, fmt.Errorf("%s: entry %d gives %q coordinates %v,%v, conflicting with %v,%v", path, i, entry.Name, coords.Lat, coords.Lon, previous.Lat, previous.Lon)
+			default:
+				log.Printf("Warning: %s entry %d moves %q from %v,%v to %v,%v", path, i, entry.Name, previous.Lat, previous.Lon, coords.Lat, coords.Lon)
+			}
+		}
+		table[name] = coords
+	}
+	return table, nil
+}
+
+// invalidLocation describes what is wrong with a location file entry, or
+// returns "" if nothing is.
+func invalidLocation(name string, coords Coordinates) string {
+	switch {
+	case name == "":
+		return "has no name"
+	case coords.Lat < -90 || coords.Lat > 90 || coords.Lon < -180 || coords.Lon > 180:
+		return fmt.Sprintf("places %q off the globe at %v,%v", name, coords.Lat, coords.Lon)
+	}
+	return ""
+}
+
+// optionalCoordinates is a lat,lon flag that stays nil until it is set.
+type optionalCoordinates struct {
+	*Coordinates
+}
+
+func (c *optionalCoordinates) String() string {
+	if c.Coordinates == nil {
+		return ""
+	}
+	return fmt.Sprintf("%g,%g", c.Lat, c.Lon)
+}
+
+func (c *optionalCoordinates) Set(value string) error {
+	rawLat, rawLon, ok := strings.Cut(value, ",")
+	if !ok {
+		return errors.New("want lat,lon")
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(rawLat), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return fmt.Errorf("invalid latitude %q", rawLat)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(rawLon), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return fmt.Errorf("invalid longitude %q", rawLon)
+	}
+	c.Coordinates = &Coordinates{Lat: lat, Lon: lon}
+	return nil
+}
+
+// defaultOrigin is where searches without a location start under
+// -empty-query=all or default-origin.
+var defaultOrigin optionalCoordinates
+
+// resolveDefaultOrigin returns defaultOrigin or, when it is unset, the
+// centroid of the catalog. It fails only for an empty catalog.
+func resolveDefaultOrigin() (resolvedOrigin, bool) {
+	if defaultOrigin.Coordinates != nil {
+		return resolvedOrigin{Lat: defaultOrigin.Lat, Lon: defaultOrigin.Lon, Label: "default origin"}, true
+	}
+	props := currentProperties()
+	if len(props) == 0 {
+		return resolvedOrigin{}, false
+	}
+	var centroid Coordinates
+	for _, prop := range props {
+		centroid.Lat += prop.Latitude
+		centroid.Lon += prop.Longitude
+	}
+	n := float64(len(props))
+	return resolvedOrigin{Lat: centroid.Lat / n, Lon: centroid.Lon / n, Label: "catalog centroid"}, true
+}
+
+func resolvePin(pin string) (resolvedOrigin, bool) {
+	coords, exists := pinCodes[pin]
+	if !exists {
+		return resolvedOrigin{}, false
+	}
+	return resolvedOrigin{Lat: coords.Lat, Lon: coords.Lon, Label: pin}, true
+}
+
+func resolveAirport(code string) (resolvedOrigin, bool) {
+	coords, exists := airportCodes[strings.ToUpper(code)]
+	if !exists {
+		return resolvedOrigin{}, false
+	}
+	return resolvedOrigin{Lat: coords.Lat, Lon: coords.Lon, Label: strings.ToUpper(code)}, true
+}
+
+type cityListing struct {
+	Name    string   `json:"name"`
+	Lat     float64  `json:"lat"`
+	Lon     float64  `json:"lon"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// citiesHandler lists the searchable city centers by name, with the
+// aliases that resolve to each.
+func citiesHandler(w http.ResponseWriter, r *http.Request) {
+	aliases := make(map[string][]string)
+	for alias, city := range cityAliases {
+		aliases[city] = append(aliases[city], alias)
+	}
+	cities := make([]cityListing, 0, len(cityCenters))
+	for city, coords := range cityCenters {
+		sort.Strings(aliases[city])
+		cities = append(cities, cityListing{Name: city, Lat: coords.Lat, Lon: coords.Lon, Aliases: aliases[city]})
+	}
+	sort.Slice(cities, func(i, j int) bool {
+		return cities[i].Name < cities[j].Name
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cities)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTempFile writes content to a file in the test's temp dir and
+// returns its path.
+func writeTempFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResolveExactAliases(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"Pink City", "jaipur"},
+		{"  new   delhi ", "delih"},
+		{"City of Lakes", "udaipur"},
+		{"jaipur", "jaipur"},
+	}
+	for _, tt := range tests {
+		origin, ok := resolveExact(tt.query)
+		if !ok || origin.City != tt.want {
+			t.Errorf("resolveExact(%q) = %q, %v; want %q", tt.query, origin.City, ok, tt.want)
+		}
+		if ok && origin.Fuzzy {
+			t.Errorf("resolveExact(%q) is marked fuzzy", tt.query)
+		}
+	}
+}
+
+func TestLoadAliases(t *testing.T) {
+	path := writeTempFile(t, "aliases.json", `{"Lake City": "Udaipur", "Jaipur Town": "jaipur", "Somewhere": "atlantis"}`)
+	aliases, err := loadAliases(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"lake city": "udaipur", "jaipur town": "jaipur"}
+	if len(aliases) != len(want) {
+		t.Errorf("loaded %v, want %v", aliases, want)
+	}
+	for alias, city := range want {
+		if aliases[alias] != city {
+			t.Errorf("alias %q resolves to %q, want %q", alias, aliases[alias], city)
+		}
+	}
+
+	if _, err := loadAliases(writeTempFile(t, "bad.json", `["udaipur"]`)); err == nil {
+		t.Error("loadAliases accepted a JSON array")
+	}
+}
+
+func TestLoadLocationTable(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	tests := []struct {
+		name        string
+		content     string
+		wantStrict  string
+		wantLenient map[string]Coordinates
+	}{
+		{
+			name:        "exact repeat",
+			content:     `[{"name": "Udaipur", "lat": 24.58, "lon": 73.71}, {"name": "udaipur", "lat": 24.58, "lon": 73.71}]`,
+			wantLenient: map[string]Coordinates{"udaipur": {24.58, 73.71}},
+		},
+		{
+			name:        "conflicting repeat",
+			content:     `[{"name": "Udaipur", "lat": 24.58, "lon": 73.71}, {"name": " UDAIPUR", "lat": 24.6, "lon": 73.7}]`,
+			wantStrict:  "conflicting",
+			wantLenient: map[string]Coordinates{"udaipur": {24.6, 73.7}},
+		},
+		{
+			name:        "blank name",
+			content:     `[{"name": "  ", "lat": 24.58, "lon": 73.71}, {"name": "Jaipur", "lat": 26.91, "lon": 75.78}]`,
+			wantStrict:  "has no name",
+			wantLenient: map[string]Coordinates{"jaipur": {26.91, 75.78}},
+		},
+		{
+			name:        "off the globe",
+			content:     `[{"name": "Udaipur", "lat": 124.58, "lon": 73.71}, {"name": "Jaipur", "lat": 26.91, "lon": -195}, {"name": "Goa", "lat": 15.49, "lon": 73.82}]`,
+			wantStrict:  "off the globe",
+			wantLenient: map[string]Coordinates{"goa": {15.49, 73.82}},
+		},
+	}
+	for _, tt := range tests {
+		path := writeTempFile(t, "cities.json", tt.content)
+		for _, strict := range []bool{true, false} {
+			strictLocations = strict
+			table, err := loadLocationTable(path, NormalizeQuery)
+			strictLocations = false
+			if strict && tt.wantStrict != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantStrict) {
+					t.Errorf("%s, strict: error %v, want one mentioning %q", tt.name, err, tt.wantStrict)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s, strict %v: %v", tt.name, strict, err)
+				continue
+			}
+			if !maps.Equal(table, tt.wantLenient) {
+				t.Errorf("%s, strict %v: loaded %v, want %v", tt.name, strict, table, tt.wantLenient)
+			}
+		}
+	}
+}
+
+func TestLoadLocationTableMalformed(t *testing.T) {
+	for _, content := range []string{
+		`{"udaipur": [24.58, 73.71]}`,
+		`[{"name": "Udaipur", "lat": "24.58", "lon": 73.71}]`,
+		`[{"name": "Udaipur", "lat": 24.58, "lon": 73.71}`,
+		`[["Udaipur", 24.58, 73.71]]`,
+	} {
+		if _, err := loadLocationTable(writeTempFile(t, "cities.json", content), NormalizeQuery); err == nil {
+			t.Errorf("loadLocationTable accepted %s", content)
+		}
+	}
+}
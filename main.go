@@ -1,201 +1,1616 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/gorilla/mux"
-	"github.com/kellydunn/golang-geo"
-	"github.com/agnivade/levenshtein"
+	"golang.org/x/sync/singleflight"
 )
 
 type Property struct {
 	Name      string  `json:"name"`
 	Latitude  float64 `json:"latitude"`
 	Longitude float64 `json:"longitude"`
+	// OpenMonths lists the months (1-12) the property operates in. An empty
+	// list means it is open all year.
+	OpenMonths []int `json:"open_months,omitempty"`
+	// UpdatedAt is when the listing was last changed, if the catalog says.
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+	// Rating is the guest rating from minRating to maxRating; zero means
+	// the property is unrated.
+	Rating float64 `json:"rating,omitempty"`
+	// Aliases are other names the property is known by, such as a shorter
+	// form of Name. Name searches match them too; responses show Name.
+	Aliases []string `json:"aliases,omitempty"`
+	// Attributes holds free-form facts about the property, such as
+	// "pool": "true", that clients filter on with the filter parameter.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+const (
+	minRating = 1.0
+	maxRating = 5.0
+)
+
+func (p Property) openIn(month time.Month) bool {
+	if len(p.OpenMonths) == 0 {
+		return true
+	}
+	for _, m := range p.OpenMonths {
+		if time.Month(m) == month {
+			return true
+		}
+	}
+	return false
 }
 
 type PropertyResponse struct {
-	Name      string  `json:"name"`
-	Distance  float64 `json:"distance_km"`
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
+	Name      string    `json:"name"`
+	Distance  float64   `json:"distance_km"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	InRadius  *bool     `json:"in_radius,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+	Rating    float64   `json:"rating,omitempty"`
+	// Attributes is the property's catalog attributes, if any.
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Type is the kind of stay, one of propertyTypes.
+	Type string `json:"type"`
+	// UnitDistance is Distance in the unit the client asked for, if any.
+	UnitDistance  *float64 `json:"distance,omitempty"`
+	AtOrigin      bool     `json:"at_origin,omitempty"`
+	OutsideRadius bool     `json:"outside_radius,omitempty"`
+	// Near lists the near= anchors the property is within the radius of.
+	Near []string `json:"near,omitempty"`
+	// DistanceLabel is Distance formatted for display, set for labels=true.
+	DistanceLabel string `json:"distance_label,omitempty"`
+	// MapURL is a static map of the property, set for maps=true when
+	// -map-url-template is configured.
+	MapURL string `json:"map_url,omitempty"`
+	// Region is the property's country and state, set for enrich=true
+	// when they are known.
+	Region *Region `json:"region,omitempty"`
+	// DurationMin is the estimated driving time in minutes, set for
+	// sort=duration when the travel time provider knows it.
+	DurationMin *float64 `json:"duration_min,omitempty"`
 }
 
 type SearchResponse struct {
-	Properties []PropertyResponse `json:"properties"`
-	Message    string             `json:"message,omitempty"`
+	Properties  []PropertyResponse `json:"properties"`
+	Message     string             `json:"message,omitempty"`
+	Suggestions []string           `json:"suggestions,omitempty"`
+	Truncated   bool               `json:"truncated,omitempty"`
+	Total       int                `json:"total,omitempty"`
+	Unit        string             `json:"unit,omitempty"`
+	Session     string             `json:"session,omitempty"`
+	Rings       []ring             `json:"rings,omitempty"`
+	Geodesy     string             `json:"geodesy,omitempty"`
+	// Centroid is the average position of the properties within the
+	// radius, for centering a map on them.
+	Centroid *Coordinates `json:"centroid,omitempty"`
+	// RadiusKm and Expansions are set for auto_expand=true: the radius the
+	// results were found within, after doubling the requested one
+	// Expansions times.
+	RadiusKm   float64 `json:"radius_km,omitempty"`
+	Expansions int     `json:"expansions,omitempty"`
+	// Sort is the order applied when the client asked for one, "distance"
+	// when sort=duration had to fall back.
+	Sort string `json:"sort,omitempty"`
+	// NextCursor resumes a cursor feed after this page; it is empty on the
+	// last one.
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Unrecognized is set when the query did not resolve to a location.
+	Unrecognized bool `json:"-"`
 }
 
 var properties = []Property{
-	{"Moustache Udaipur Luxuria", 24.57799888, 73.68263271},
-	{"Moustache Udaipur", 24.58145726, 73.68223671},
-	{"Moustache Udaipur Verandah", 24.58350565, 73.68120777},
-	{"Moustache Jaipur", 27.29124839, 75.89630143},
-	{"Moustache Jaisalmer", 27.20578572, 70.85906998},
-	{"Moustache Jodhpur", 26.30365556, 73.03570908},
-	{"Moustache Agra", 27.26156953, 78.07524716},
-	{"Moustache Delhi", 28.61257139, 77.28423582},
-	{"Moustache Rishikesh Luxuria", 30.13769036, 78.32465767},
-	{"Moustache Rishikesh Riverside Resort", 30.10216117, 78.38458848},
-	{"Moustache Hostel Varanasi", 25.2992622, 82.99691388},
-	{"Moustache Goa Luxuria", 15.6135195, 73.75705228},
-	{"Moustache Koksar Luxuria", 32.4357785, 77.18518717},
-	{"Moustache Daman", 20.41486263, 72.83282455},
-	{"Panarpani Retreat", 22.52805539, 78.43116291},
-	{"Moustache Pushkar", 26.48080513, 74.5613783},
-	{"Moustache Khajuraho", 24.84602104, 79.93139381},
-	{"Moustache Manali", 32.28818695, 77.17702523},
-	{"Moustache Bhintal Luxuria", 29.36552248, 79.53481747},
-	{"Moustache Srinagar", 34.11547314, 74.88701741},
-	{"Moustache Ranthambore Luxuria", 26.05471373, 76.42953726},
-	{"Moustache Coimbatore", 11.02064612, 76.96293531},
-	{"Moustache Shoja", 31.56341267, 77.36733331},
-}
-
-var cityCenters = map[string]struct {
-	Lat float64
-	Lon float64
-}{
-	"udaipur":    {24.5854, 73.7125},
-	"jaipur":     {26.9124, 75.7873},
-	"jaisalmer":  {26.9157, 70.9083},
-	"delih":      {28.7041, 77.1025},
-	"udiapur":    {24.5854, 73.7125},
+	{Name: "Moustache Udaipur Luxuria", Latitude: 24.57799888, Longitude: 73.68263271},
+	{Name: "Moustache Udaipur", Latitude: 24.58145726, Longitude: 73.68223671},
+	{Name: "Moustache Udaipur Verandah", Latitude: 24.58350565, Longitude: 73.68120777},
+	{Name: "Moustache Jaipur", Latitude: 27.29124839, Longitude: 75.89630143},
+	{Name: "Moustache Jaisalmer", Latitude: 27.20578572, Longitude: 70.85906998},
+	{Name: "Moustache Jodhpur", Latitude: 26.30365556, Longitude: 73.03570908},
+	{Name: "Moustache Agra", Latitude: 27.26156953, Longitude: 78.07524716},
+	{Name: "Moustache Delhi", Latitude: 28.61257139, Longitude: 77.28423582},
+	{Name: "Moustache Rishikesh Luxuria", Latitude: 30.13769036, Longitude: 78.32465767},
+	{Name: "Moustache Rishikesh Riverside Resort", Latitude: 30.10216117, Longitude: 78.38458848},
+	{Name: "Moustache Hostel Varanasi", Latitude: 25.2992622, Longitude: 82.99691388},
+	{Name: "Moustache Goa Luxuria", Aliases: []string{"Moustache Goa"}, Latitude: 15.6135195, Longitude: 73.75705228},
+	{Name: "Moustache Koksar Luxuria", Latitude: 32.4357785, Longitude: 77.18518717, OpenMonths: []int{5, 6, 7, 8, 9, 10}},
+	{Name: "Moustache Daman", Latitude: 20.41486263, Longitude: 72.83282455},
+	{Name: "Panarpani Retreat", Latitude: 22.52805539, Longitude: 78.43116291},
+	{Name: "Moustache Pushkar", Latitude: 26.48080513, Longitude: 74.5613783},
+	{Name: "Moustache Khajuraho", Latitude: 24.84602104, Longitude: 79.93139381},
+	{Name: "Moustache Manali", Latitude: 32.28818695, Longitude: 77.17702523},
+	{Name: "Moustache Bhintal Luxuria", Latitude: 29.36552248, Longitude: 79.53481747},
+	{Name: "Moustache Srinagar", Latitude: 34.11547314, Longitude: 74.88701741},
+	{Name: "Moustache Ranthambore Luxuria", Latitude: 26.05471373, Longitude: 76.42953726},
+	{Name: "Moustache Coimbatore", Latitude: 11.02064612, Longitude: 76.96293531},
+	{Name: "Moustache Shoja", Latitude: 31.56341267, Longitude: 77.36733331},
+}
+
+type Coordinates struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+var cityCenters = map[string]Coordinates{
+	"udaipur":   {24.5854, 73.7125},
+	"jaipur":    {26.9124, 75.7873},
+	"jaisalmer": {26.9157, 70.9083},
+	"delih":     {28.7041, 77.1025},
+	"udiapur":   {24.5854, 73.7125},
+}
+
+var searchCache Cache = newMemoryCache()
+
+// searchOptions holds the per-request knobs that change a search's result.
+// It is part of the cache key, so every field must be comparable and print
+// deterministically with %v.
+type searchOptions struct {
+	ExcludeSelf bool
+	Pin         string
+	Airport     string
+	Month       time.Month
+	ExactOnly   bool
+	// Sort is the primary order, "distance", "duration" or "name". Empty
+	// means distance without reporting it.
+	Sort string
+	// SecondarySort breaks distance ties: "name", "lat" or "lon".
+	SecondarySort string
+	// Formula names an entry of distanceFormulas; empty means great-circle.
+	Formula string
+	// IncludeAll returns properties beyond the radius too, flagged with
+	// in_radius.
+	IncludeAll bool
+	// DefaultOrigin searches from the default origin, for requests
+	// without a location under -empty-query=all or default-origin.
+	DefaultOrigin bool
+	// Labels adds a localized distance_label to each property.
+	Labels bool
+	// OmitMessage leaves out the human-readable message.
+	OmitMessage bool
+	// Maps adds a map_url to each property.
+	Maps bool
+	// Enrich adds each property's region.
+	Enrich bool
+	// WindowStart and WindowEnd, when set, keep only the properties from
+	// the WindowStart-th to the WindowEnd-th nearest, counting from 1.
+	WindowStart int
+	WindowEnd   int
+	// PageSize, when set, returns a cursor feed page of that many
+	// properties, resuming after Cursor unless it is empty.
+	PageSize int
+	Cursor   string
+	// AutoExpand doubles the radius while it holds fewer than
+	// AutoExpandMin properties.
+	AutoExpand    bool
+	AutoExpandMin int
+	// Exclude is a sorted, comma-separated list of normalized property names
+	// to leave out of the results.
+	Exclude string
+	// RadiusKm is the search radius; zero means defaultRadiusKm.
+	RadiusKm float64
+	// Limit caps the number of results; zero means no limit.
+	Limit int
+	// ExclusiveBoundary leaves out properties exactly on the radius.
+	ExclusiveBoundary bool
+	// Lang is the messageCatalog language of Message; empty means
+	// defaultLanguage.
+	Lang string
+	// Name, when set, keeps only properties whose name matches it under
+	// NameMatch, one of nameMatchModes.
+	Name      string
+	NameMatch string
+	// Unit names an entry of distanceUnits to report distances in, in
+	// addition to kilometers, rounded to Precision decimals.
+	Unit      string
+	Precision int
+	// ZeroDistance is one of zeroDistanceModes.
+	ZeroDistance string
+	// FallbackNearest returns the nearest property, flagged outside_radius,
+	// when none are within the radius.
+	FallbackNearest bool
+	// MinRating drops rated properties below it. Unrated properties are
+	// kept, since a missing rating says nothing about quality.
+	MinRating float64
+	// Filter is a canonical list of attribute predicates from
+	// parseAttributeFilter that every result must satisfy.
+	Filter string
+	// Types is a sorted, comma-separated list of propertyTypes to keep.
+	Types string
+	// Radii is a comma-separated, ascending list of ring radii in
+	// kilometers. When set, the largest is the search radius.
+	Radii string
+	// Near is a sorted, comma-separated list of normalized property names.
+	// When set, it replaces a single origin: results are the properties
+	// within the radius of any of them, ranked by the nearest.
+	Near string
+	// Bearing keeps properties within BearingTolerance degrees of that
+	// compass direction from the origin. A zero tolerance disables it.
+	Bearing          float64
+	BearingTolerance float64
+}
+
+// defaultBearingTolerance is the window either side of bearing used when a
+// search does not set bearing_tolerance.
+const defaultBearingTolerance = 45.0
+
+// maxRings bounds the number of radii a search may ask for.
+const maxRings = 10
+
+// ring is one band of a tiered search: the properties from (exclusive) to
+// to (inclusive), in the request's unit.
+type ring struct {
+	From       float64            `json:"from"`
+	To         float64            `json:"to"`
+	Properties []PropertyResponse `json:"properties"`
+}
+
+// zeroDistanceModes control properties at the search origin itself, such
+// as the searched property and any sharing its coordinates: "keep" treats
+// them like any other, "first" keeps them ahead of reorderings such as
+// fuzzy name ranking, "exclude" drops them and "label" marks them with
+// at_origin.
+var zeroDistanceModes = []string{"keep", "first", "exclude", "label"}
+
+// emptyQueryModes control searches that name no location: "error" rejects
+// them with 400, "all" returns the whole catalog ranked from the default
+// origin and "default-origin" searches around it as if it had been asked
+// for.
+var emptyQueryModes = []string{"error", "all", "default-origin"}
+
+// defaultRadiusKm is the search radius used when a request does not set one.
+const defaultRadiusKm = 50.0
+
+// boundaryEpsilonKm is how close to the radius a distance must be to count
+// as on the boundary, so that rounding noise in the distance formulas does
+// not move a property in and out of results.
+const boundaryEpsilonKm = 1e-9
+
+func withinRadius(distance, radius float64, exclusive bool) bool {
+	if exclusive {
+		return distance < radius-boundaryEpsilonKm
+	}
+	return distance <= radius+boundaryEpsilonKm
 }
 
 var (
-	searchCache = make(map[string]SearchResponse)
-	cacheMutex  sync.RWMutex
+	maxConcurrentSearches = 100
+	tlsCertFile           string
+	tlsKeyFile            string
+	pinCodesFile          string
+	aliasesFile           string
+	airportsFile          string
+	gzipMinBytes          = 1024
+	cacheBackend          = "memory"
+	redisAddr             = "localhost:6379"
+	redisPassword         string
+	redisTTL              = 10 * time.Minute
+	searchBudget          = 1500 * time.Millisecond
+	exactOnlyMatching     bool
+	maxResults            = 1000
+	geocoderURL           string
+	travelTimeURL         string
+	regionURL             string
+	propertiesFile        string
+	validationMode        = "strict"
+	dailyQuotaLimit       int
+	requireAPIKeys        bool
+	apiKeysFile           string
+	cacheMaxAge           = 5 * time.Minute
+	maxRadiusKm           = 500.0
+	autoExpandMin         = 1
+	maxAutoExpansions     = 3
+	messagesFile          string
+	editDistanceName      string
+	adminToken            string
+	zeroDistanceMode      = "keep"
+	emptyQueryMode        = "error"
+	citiesFile            string
+	basePath              string
+	probesAtRoot          bool
 )
 
-func calculateDistance(lat1, lon1, lat2, lon2 float64) float64 {
-	p1 := geo.NewPoint(lat1, lon1)
-	p2 := geo.NewPoint(lat2, lon2)
-	return p1.GreatCircleDistance(p2)
+// resolvedOrigin is the point a search is centered on.
+type resolvedOrigin struct {
+	Lat   float64
+	Lon   float64
+	Label string
+	// City is the cityCenters key the origin came from, if any.
+	City         string
+	FromProperty bool
+	Fuzzy        bool
+}
+
+// resolveOrigin finds the point a free-text query refers to. Unless
+// exactOnly is set, queries that match nothing exactly are tried against the
+// geocoder and then fuzzy matching against fuzzyPool.
+func resolveOrigin(ctx context.Context, query string, exactOnly bool) (resolvedOrigin, bool) {
+	if origin, ok := resolveExact(query); ok {
+		return origin, true
+	}
+	if exactOnly {
+		return resolvedOrigin{}, false
+	}
+	if coords, ok := geocode(ctx, query); ok {
+		return resolvedOrigin{Lat: coords.Lat, Lon: coords.Lon, Label: NormalizeQuery(query)}, true
+	}
+	if origin, ok := findBestMatch(query); ok {
+		log.Printf("Fuzzy matched '%s' to '%s'", query, origin.Label)
+		return origin, true
+	}
+	return resolvedOrigin{}, false
+}
+
+// resolveExact matches query against city names, their aliases and
+// property names.
+func resolveExact(query string) (resolvedOrigin, bool) {
+	city := resolveAlias(NormalizeQuery(query))
+	if coords, exists := cityCenters[city]; exists {
+		return resolvedOrigin{Lat: coords.Lat, Lon: coords.Lon, Label: city, City: city}, true
+	}
+	if prop, exists := findPropertyByName(query); exists {
+		return resolvedOrigin{Lat: prop.Latitude, Lon: prop.Longitude, Label: prop.Name, FromProperty: true}, true
+	}
+	return resolvedOrigin{}, false
 }
 
-func findBestCityMatch(query string) string {
-	query = strings.ToLower(query)
-	var bestMatch string
-	minDistance := 2
-	for city := range cityCenters {
-		distance := levenshtein.ComputeDistance(query, city)
-		if distance < minDistance {
-			minDistance = distance
-			bestMatch = city
+// buildRings splits results, already filtered to the largest radius, into
+// the bands between consecutive opts.Radii. The first band also holds
+// properties at the origin itself.
+func buildRings(results []PropertyResponse, opts searchOptions) []ring {
+	var rings []ring
+	from := 0.0
+	for i, raw := range strings.Split(opts.Radii, ",") {
+		to, _ := strconv.ParseFloat(raw, 64)
+		band := ring{From: from, To: to, Properties: []PropertyResponse{}}
+		if opts.Unit != "" {
+			band.From = convertDistance(from, opts.Unit, opts.Precision)
+			band.To = convertDistance(to, opts.Unit, opts.Precision)
 		}
+		for _, result := range results {
+			beyondFrom := i == 0 || !withinRadius(result.Distance, from, false)
+			if !result.OutsideRadius && beyondFrom && withinRadius(result.Distance, to, opts.ExclusiveBoundary) {
+				band.Properties = append(band.Properties, result)
+			}
+		}
+		rings = append(rings, band)
+		from = to
+	}
+	return rings
+}
+
+// lessByDistanceThen orders by distance, then by the given secondary key,
+// falling back to name so the order is fully deterministic.
+func lessByDistanceThen(a, b PropertyResponse, secondary string) bool {
+	if a.Distance != b.Distance {
+		return a.Distance < b.Distance
+	}
+	switch secondary {
+	case "lat":
+		if a.Latitude != b.Latitude {
+			return a.Latitude < b.Latitude
+		}
+	case "lon":
+		if a.Longitude != b.Longitude {
+			return a.Longitude < b.Longitude
+		}
+	}
+	return a.Name < b.Name
+}
+
+// lessByName orders by name, ignoring case and spacing, and breaks ties
+// between properties with the same name by distance and then coordinates,
+// so that sort=name gives the same order on every run.
+func lessByName(a, b PropertyResponse) bool {
+	if an, bn := NormalizeQuery(a.Name), NormalizeQuery(b.Name); an != bn {
+		return an < bn
 	}
-	return bestMatch
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	if a.Distance != b.Distance {
+		return a.Distance < b.Distance
+	}
+	if a.Latitude != b.Latitude {
+		return a.Latitude < b.Latitude
+	}
+	return a.Longitude < b.Longitude
+}
+
+// searchCacheKey includes the catalog version so that responses computed
+// from a previous catalog are never served after a reload. Options applied
+// to the cached response on the way out are left out, so that every page
+// or window of a search shares one entry.
+func searchCacheKey(query string, opts searchOptions) string {
+	opts.PageSize, opts.Cursor = 0, ""
+	opts.WindowStart, opts.WindowEnd = 0, 0
+	opts.OmitMessage, opts.Enrich = false, false
+	return fmt.Sprintf("%s|v%d|%+v", query, catalogVersion(), opts)
 }
 
-func searchProperties(query string) SearchResponse {
+func searchProperties(ctx context.Context, query string, opts searchOptions) SearchResponse {
 	startTime := time.Now()
 	query = strings.TrimSpace(query)
 
-	cacheKey := strings.ToLower(query)
-	cacheMutex.RLock()
-	if cached, exists := searchCache[cacheKey]; exists {
-		cacheMutex.RUnlock()
+	searchCounts.record(searchedPlace(query, opts))
+	cacheKey := searchCacheKey(NormalizeQuery(query), opts)
+	cached, exists := searchCache.Get(cacheKey)
+	searchCacheMetrics.record(exists)
+	if exists {
 		log.Printf("Cache hit for: %s", query)
-		return cached
+		return enrichRegions(ctx, withoutMessage(cached, opts), opts)
 	}
-	cacheMutex.RUnlock()
 
-	var targetLat, targetLon float64
-	var found bool
-
-	if coords, exists := cityCenters[strings.ToLower(query)]; exists {
-		targetLat, targetLon = coords.Lat, coords.Lon
-		found = true
-	} else {
-		bestMatch := findBestCityMatch(query)
-		if bestMatch != "" {
-			log.Printf("Fuzzy matched '%s' to '%s'", query, bestMatch)
-			targetLat, targetLon = cityCenters[bestMatch].Lat, cityCenters[bestMatch].Lon
-			found = true
-			cacheKey = bestMatch
+	// Concurrent misses for the same key share one computation. It keeps the
+	// leader's deadline but not its cancellation, so one client hanging up
+	// does not cut the others' results short.
+	shared, _, joined := searchFlights.Do(cacheKey, func() (any, error) {
+		flightCtx := context.WithoutCancel(ctx)
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			flightCtx, cancel = context.WithDeadline(flightCtx, deadline)
+			defer cancel()
 		}
+		return computeSearch(flightCtx, query, opts, cacheKey), nil
+	})
+	if joined {
+		log.Printf("Shared in-flight search for: %s", query)
+	}
+	logSearchDuration(query, opts, time.Since(startTime))
+	return enrichRegions(ctx, withoutMessage(shared.(SearchResponse), opts), opts)
+}
+
+// slowSearchThreshold, from SLOW_THRESHOLD_MS, limits search duration logs
+// to searches that take longer; zero logs them all. LOG_LEVEL=debug logs
+// them all regardless.
+var (
+	slowSearchThreshold time.Duration
+	debugLogging        bool
+)
+
+func logSearchDuration(query string, opts searchOptions, elapsed time.Duration) {
+	switch {
+	case slowSearchThreshold > 0 && elapsed > slowSearchThreshold:
+		log.Printf("WARN slow search for '%s' took %v (threshold %v), options %+v", query, elapsed, slowSearchThreshold, opts)
+	case slowSearchThreshold == 0 || debugLogging:
+		log.Printf("Search completed in %v", elapsed)
+	}
+}
+
+// applyWindow narrows a response to its WindowStart-th through
+// WindowEnd-th nearest properties, with Total reporting how many there were.
+// Its error, for a window starting past the last result, is meant for the
+// client.
+func applyWindow(response SearchResponse, opts searchOptions) (SearchResponse, error) {
+	if opts.WindowStart == 0 || response.Unrecognized {
+		return response, nil
+	}
+	count := len(response.Properties)
+	if response.Total > count {
+		count = response.Total
+	}
+	if opts.WindowStart > len(response.Properties) {
+		return SearchResponse{}, fmt.Errorf("Query parameter 'window' starts at %d but there are only %d results", opts.WindowStart, count)
+	}
+	end := min(opts.WindowEnd, len(response.Properties))
+	response.Properties = response.Properties[opts.WindowStart-1 : end]
+	response.Total = count
+	return response, nil
+}
+
+// withoutMessage drops the human-readable message for message=false. It is
+// applied on the way out so that the cached response keeps it.
+func withoutMessage(response SearchResponse, opts searchOptions) SearchResponse {
+	if opts.OmitMessage {
+		response.Message = ""
+	}
+	return response
+}
+
+// searchFlights deduplicates concurrent searchProperties cache misses.
+var searchFlights singleflight.Group
+
+// searchOrigin finds where a search runs from: its pin, airport, near
+// anchors or the default origin, or otherwise whatever resolveQuery makes
+// of the query. source names which of these it was, and is empty for the
+// query.
+func searchOrigin(opts searchOptions, resolveQuery func() (resolvedOrigin, bool)) (origin resolvedOrigin, anchors []resolvedOrigin, source string, found bool) {
+	switch {
+	case opts.Pin != "":
+		origin, found = resolvePin(opts.Pin)
+		return origin, nil, "pin", found
+	case opts.Airport != "":
+		origin, found = resolveAirport(opts.Airport)
+		return origin, nil, "airport", found
+	case opts.Near != "":
+		anchors = resolveAnchors(opts.Near)
+		return resolvedOrigin{}, anchors, "near", len(anchors) > 0
+	case opts.DefaultOrigin:
+		origin, found = resolveDefaultOrigin()
+		return origin, nil, "default", found
+	}
+	origin, found = resolveQuery()
+	return origin, nil, "", found
+}
+
+// computeSearch runs a search that missed the cache and caches its result
+// unless it is partial.
+func computeSearch(ctx context.Context, query string, opts searchOptions, cacheKey string) SearchResponse {
+	origin, anchors, _, found := searchOrigin(opts, func() (resolvedOrigin, bool) {
+		return resolveOrigin(ctx, query, opts.ExactOnly)
+	})
+	if found && origin.Fuzzy {
+		cacheKey = searchCacheKey(origin.Label, opts)
 	}
 
 	if !found {
 		response := SearchResponse{
-			Properties: []PropertyResponse{},
-			Message:    "Location not recognized",
+			Properties:   []PropertyResponse{},
+			Message:      localize(opts.Lang, msgUnrecognized),
+			Unrecognized: true,
+		}
+		if opts.Pin == "" && opts.Airport == "" && opts.Near == "" && !opts.DefaultOrigin {
+			response.Suggestions = suggestCities(query, maxSuggestions)
+		}
+		// With a geocoder, misses are remembered by its backoff instead so
+		// the place is looked up again once the backoff expires.
+		if geocoder == nil && cacheKey != "" && searchCacheGuard.allow() {
+			searchCache.Set(cacheKey, response)
 		}
-		cacheMutex.Lock()
-		searchCache[cacheKey] = response
-		cacheMutex.Unlock()
 		return response
 	}
 
+	radius := opts.RadiusKm
+	if radius == 0 {
+		radius = defaultRadiusKm
+	}
+
+	excluded := make(map[string]bool)
+	if opts.Exclude != "" {
+		for _, name := range strings.Split(opts.Exclude, ",") {
+			excluded[name] = true
+		}
+	}
+
+	// With AutoExpand, a radius holding fewer than AutoExpandMin properties
+	// is doubled, up to maxRadiusKm and maxAutoExpansions times.
+	var truncated bool
+	var nameScores map[string]float64
 	var results []PropertyResponse
-	for _, prop := range properties {
-		distance := calculateDistance(targetLat, targetLon, prop.Latitude, prop.Longitude)
-		if distance <= 50 {
-			results = append(results, PropertyResponse{
-				Name:      prop.Name,
-				Distance:  distance,
-				Latitude:  prop.Latitude,
-				Longitude: prop.Longitude,
-			})
+	var inRadius, expansions int
+	for {
+		var ranked []rankedProperty
+		var nearAnchors map[string][]string
+		if anchors != nil {
+			ranked, nearAnchors, truncated = rankNearAnchors(ctx, anchors, radius, opts)
+		} else {
+			ranked, truncated = memoizedRanking(ctx, origin, opts.Formula)
+		}
+		nameScores = make(map[string]float64)
+		results = []PropertyResponse{}
+		inRadius = 0
+		for _, result := range ranked {
+			within := withinRadius(result.Distance, radius, opts.ExclusiveBoundary)
+			// With FallbackNearest, an empty radius still yields the nearest
+			// property that passes the other filters.
+			outside := false
+			if !within && !opts.IncludeAll {
+				if !opts.FallbackNearest || len(results) > 0 {
+					break
+				}
+				outside = true
+			}
+			if opts.ExcludeSelf && origin.FromProperty && result.Latitude == origin.Lat && result.Longitude == origin.Lon {
+				continue
+			}
+			if opts.ExcludeSelf && isAnchor(anchors, result.Property) {
+				continue
+			}
+			atOrigin := result.Distance <= boundaryEpsilonKm
+			if atOrigin && opts.ZeroDistance == "exclude" {
+				continue
+			}
+			if opts.Month != 0 && !result.openIn(opts.Month) {
+				continue
+			}
+			if result.Rating != 0 && result.Rating < opts.MinRating {
+				continue
+			}
+			if !result.matchesAttributes(opts.Filter) {
+				continue
+			}
+			if opts.Types != "" && !slices.Contains(strings.Split(opts.Types, ","), result.propertyType()) {
+				continue
+			}
+			// Properties at the origin have no direction and are kept.
+			if opts.BearingTolerance > 0 && !atOrigin &&
+				bearingDifference(initialBearing(origin.Lat, origin.Lon, result.Latitude, result.Longitude), opts.Bearing) > opts.BearingTolerance {
+				continue
+			}
+			if excluded[NormalizeQuery(result.Name)] {
+				continue
+			}
+			if opts.Name != "" {
+				score, ok := matchPropertyName(opts.NameMatch, opts.Name, result.Property)
+				if !ok {
+					continue
+				}
+				nameScores[result.Name] = score
+			}
+			item := result.response()
+			item.AtOrigin = atOrigin && opts.ZeroDistance == "label"
+			if opts.Unit != "" {
+				converted := convertDistance(result.Distance, opts.Unit, opts.Precision)
+				item.UnitDistance = &converted
+			}
+			if opts.IncludeAll {
+				item.InRadius = &within
+			}
+			if within {
+				inRadius++
+			}
+			item.OutsideRadius = outside
+			if opts.Labels {
+				item.DistanceLabel = distanceLabel(opts.Lang, result.Distance, opts.Unit)
+			}
+			if opts.Maps {
+				item.MapURL = mapURL(item.Latitude, item.Longitude)
+			}
+			item.Near = nearAnchors[result.Name]
+			results = append(results, item)
+			if outside {
+				break
+			}
 		}
+		if !opts.AutoExpand || inRadius >= opts.AutoExpandMin || radius >= maxRadiusKm || expansions == maxAutoExpansions {
+			break
+		}
+		radius = min(radius*2, maxRadiusKm)
+		expansions++
+	}
+	if opts.SecondarySort != "" {
+		sort.Slice(results, func(i, j int) bool {
+			return lessByDistanceThen(results[i], results[j], opts.SecondarySort)
+		})
+	}
+	if opts.Sort == "name" {
+		sort.Slice(results, func(i, j int) bool {
+			return lessByName(results[i], results[j])
+		})
+	}
+	sortedByDuration := false
+	if opts.Sort == "duration" && anchors == nil {
+		sortedByDuration = sortByDuration(ctx, Coordinates{Lat: origin.Lat, Lon: origin.Lon}, results)
+	}
+	if opts.NameMatch == "fuzzy" {
+		sort.SliceStable(results, func(i, j int) bool {
+			if opts.ZeroDistance == "first" {
+				iZero, jZero := results[i].Distance <= boundaryEpsilonKm, results[j].Distance <= boundaryEpsilonKm
+				if iZero != jZero {
+					return iZero
+				}
+			}
+			return nameScores[results[i].Name] > nameScores[results[j].Name]
+		})
+	}
+	if opts.Limit > 0 && len(results) > opts.Limit {
+		results = results[:opts.Limit]
 	}
 
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].Distance < results[j].Distance
-	})
+	shownRadius, unitName := localizedRadius(opts.Lang, radius, opts.Unit, opts.Precision)
+	response := SearchResponse{
+		Properties: results,
+		Message:    localize(opts.Lang, msgFound, inRadius, shownRadius, unitName),
+		Unit:       opts.Unit,
+		Geodesy:    "great-circle",
+	}
+	if opts.Formula != "" {
+		response.Geodesy = opts.Formula
+	}
+	// A provider failure is not cached so the next search tries it again.
+	durationsFailed := opts.Sort == "duration" && !sortedByDuration && travelTimer != nil && anchors == nil
+	if opts.AutoExpand {
+		response.RadiusKm = radius
+		response.Expansions = expansions
+	}
+	if opts.Sort != "" {
+		response.Sort = opts.Sort
+		if opts.Sort == "duration" && !sortedByDuration {
+			response.Sort = "distance"
+		}
+	}
+	if inRadius == 0 {
+		response.Message = localize(opts.Lang, msgNoneFound, shownRadius, unitName)
+	}
+	if len(results) > maxResults {
+		response.Properties = results[:maxResults]
+		response.Truncated = true
+		response.Total = len(results)
+	}
+	if opts.Radii != "" {
+		response.Rings = buildRings(response.Properties, opts)
+	}
+	response.Centroid = resultsCentroid(response.Properties)
 
-	var response SearchResponse
-	if len(results) == 0 {
-		response = SearchResponse{
-			Properties: []PropertyResponse{},
-			Message:    "No properties found within 50km",
+	// The fallback runs uncached, which also keeps it from falling back in
+	// turn; its result is cached below under the original key.
+	if !truncated && cacheKey != "" && inRadius < fuzzyFallbackMin && canFallBackToFuzzy(origin, opts) {
+		if fallback, ok := fuzzyFallback(ctx, query, origin, opts, inRadius); ok {
+			response = fallback
+			truncated = fallback.Truncated
 		}
-	} else {
-		response = SearchResponse{
-			Properties: results,
-			Message:    fmt.Sprintf("Found %d properties within 50km", len(results)),
+	}
+
+	if truncated {
+		response.Truncated = true
+		log.Printf("Search for '%s' exceeded its budget, returning partial results", query)
+	} else if !durationsFailed && cacheKey != "" && searchCacheGuard.allow() {
+		searchCache.Set(cacheKey, response)
+	}
+	return response
+}
+
+// fuzzyFallbackMin, when set, retries exact matches that find fewer
+// properties within the radius from their best fuzzy alternative. With
+// auto_expand the exact match expands first, so the fallback only runs once
+// expanding up to its limit has not found enough.
+var fuzzyFallbackMin = 0
+
+// canFallBackToFuzzy reports whether a search from origin may fall back to
+// a fuzzy match: only free-text queries that matched exactly qualify.
+func canFallBackToFuzzy(origin resolvedOrigin, opts searchOptions) bool {
+	return !origin.Fuzzy && !opts.ExactOnly && opts.Pin == "" && opts.Airport == "" && opts.Near == "" && !opts.DefaultOrigin
+}
+
+// fuzzyFallback searches from the best fuzzy match for query other than
+// origin. It reports false when there is none or it finds no more than
+// inRadius properties within the radius.
+func fuzzyFallback(ctx context.Context, query string, origin resolvedOrigin, opts searchOptions, inRadius int) (SearchResponse, bool) {
+	alternative, ok := findBestMatchExcept(query, origin.Label)
+	if !ok {
+		return SearchResponse{}, false
+	}
+	response := computeSearch(ctx, alternative.Label, opts, "")
+	if response.Unrecognized || countInRadius(response.Properties) <= inRadius {
+		return SearchResponse{}, false
+	}
+	log.Printf("Exact match '%s' found %d properties, falling back to fuzzy match '%s'", origin.Label, inRadius, alternative.Label)
+	return response, true
+}
+
+// countInRadius counts the results within the search radius.
+func countInRadius(results []PropertyResponse) int {
+	count := 0
+	for _, result := range results {
+		if result.withinSearchRadius() {
+			count++
 		}
 	}
+	return count
+}
 
-	cacheMutex.Lock()
-	searchCache[cacheKey] = response
-	cacheMutex.Unlock()
+// withinSearchRadius reports whether the result is within the search
+// radius rather than returned only for include_all or fallback_nearest.
+func (p PropertyResponse) withinSearchRadius() bool {
+	return !p.OutsideRadius && (p.InRadius == nil || *p.InRadius)
+}
 
-	log.Printf("Search completed in %v", time.Since(startTime))
-	return response
+// parseSearchParams reads the search parameters shared by GET and POST
+// searches. Its errors are meant for the client.
+func parseSearchParams(params url.Values) (string, searchOptions, error) {
+	// Whitespace-only queries count as empty rather than reaching fuzzy
+	// matching as "".
+	query := strings.TrimSpace(params.Get("q"))
+	pin := strings.TrimSpace(params.Get("pin"))
+	airport := strings.ToUpper(strings.TrimSpace(params.Get("airport")))
+	near := params.Get("near")
+	opts := searchOptions{Pin: pin, Airport: airport, ExactOnly: exactOnlyMatching}
+	if query == "" && pin == "" && airport == "" && strings.TrimSpace(near) == "" {
+		if emptyQueryMode == "error" {
+			return "", searchOptions{}, errors.New("Query parameter 'q', 'pin', 'airport' or 'near' is required")
+		}
+		opts.DefaultOrigin = true
+		opts.IncludeAll = emptyQueryMode == "all"
+	}
+	if strings.TrimSpace(near) != "" {
+		var names []string
+		for _, name := range strings.Split(near, ",") {
+			if name = NormalizeQuery(name); name == "" || slices.Contains(names, name) {
+				continue
+			}
+			if _, exists := findPropertyByName(name); !exists {
+				return "", searchOptions{}, fmt.Errorf("Query parameter 'near' names unknown property %q", name)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		opts.Near = strings.Join(names, ",")
+	}
+	if raw := params.Get("exclude_self"); raw != "" {
+		excludeSelf, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", searchOptions{}, errors.New("Query parameter 'exclude_self' must be a boolean")
+		}
+		opts.ExcludeSelf = excludeSelf
+	}
+	if raw := params.Get("exact"); raw != "" {
+		exact, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", searchOptions{}, errors.New("Query parameter 'exact' must be a boolean")
+		}
+		opts.ExactOnly = opts.ExactOnly || exact
+	}
+	switch sortBy := params.Get("sort"); sortBy {
+	case "", "distance", "duration", "name":
+		opts.Sort = sortBy
+	default:
+		return "", searchOptions{}, errors.New("Query parameter 'sort' must be 'distance', 'duration' or 'name'")
+	}
+	switch sort2 := params.Get("sort2"); sort2 {
+	case "", "name", "lat", "lon":
+		opts.SecondarySort = sort2
+	default:
+		return "", searchOptions{}, errors.New("Query parameter 'sort2' must be one of name, lat, lon")
+	}
+	// geodesy is the documented name of the earth model; formula is kept
+	// for existing clients.
+	formula := params.Get("geodesy")
+	if legacy := params.Get("formula"); legacy != "" {
+		if formula != "" && formula != legacy {
+			return "", searchOptions{}, errors.New("Query parameters 'geodesy' and 'formula' disagree")
+		}
+		formula = legacy
+	}
+	if formula != "" && formula != "great-circle" {
+		if _, exists := distanceFormulas[formula]; !exists {
+			return "", searchOptions{}, errors.New("Query parameter 'geodesy' must be great-circle or vincenty")
+		}
+		opts.Formula = formula
+	}
+	if raw := params.Get("include_all"); raw != "" {
+		includeAll, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", searchOptions{}, errors.New("Query parameter 'include_all' must be a boolean")
+		}
+		opts.IncludeAll = includeAll
+	}
+	if raw := params.Get("labels"); raw != "" {
+		labels, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", searchOptions{}, errors.New("Query parameter 'labels' must be a boolean")
+		}
+		opts.Labels = labels
+	}
+	if raw := params.Get("auto_expand"); raw != "" {
+		autoExpand, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", searchOptions{}, errors.New("Query parameter 'auto_expand' must be a boolean")
+		}
+		opts.AutoExpand = autoExpand
+	}
+	if opts.AutoExpand {
+		opts.AutoExpandMin = autoExpandMin
+		if raw := params.Get("auto_expand_min"); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value < 1 {
+				return "", searchOptions{}, errors.New("Query parameter 'auto_expand_min' must be a positive integer")
+			}
+			opts.AutoExpandMin = value
+		}
+	}
+	if raw := params.Get("enrich"); raw != "" {
+		enrich, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", searchOptions{}, errors.New("Query parameter 'enrich' must be a boolean")
+		}
+		opts.Enrich = enrich
+	}
+	if raw := params.Get("maps"); raw != "" {
+		maps, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", searchOptions{}, errors.New("Query parameter 'maps' must be a boolean")
+		}
+		opts.Maps = maps
+	}
+	if raw := params.Get("message"); raw != "" {
+		message, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", searchOptions{}, errors.New("Query parameter 'message' must be a boolean")
+		}
+		opts.OmitMessage = !message
+	}
+	switch params.Get("boundary") {
+	case "", "inclusive":
+	case "exclusive":
+		opts.ExclusiveBoundary = true
+	default:
+		return "", searchOptions{}, errors.New("Query parameter 'boundary' must be 'inclusive' or 'exclusive'")
+	}
+	if raw := params.Get("min_rating"); raw != "" {
+		rating, err := strconv.ParseFloat(raw, 64)
+		if err != nil || rating < minRating || rating > maxRating {
+			return "", searchOptions{}, fmt.Errorf("Query parameter 'min_rating' must be a number from %g to %g", minRating, maxRating)
+		}
+		opts.MinRating = rating
+	}
+	if raw := params.Get("filter"); raw != "" {
+		filter, err := parseAttributeFilter(raw)
+		if err != nil {
+			return "", searchOptions{}, err
+		}
+		opts.Filter = filter
+	}
+	if raw := params.Get("type"); raw != "" {
+		kinds, err := parsePropertyTypes(raw)
+		if err != nil {
+			return "", searchOptions{}, err
+		}
+		opts.Types = kinds
+	}
+	if raw := params.Get("bearing"); raw != "" {
+		bearing, err := strconv.ParseFloat(raw, 64)
+		if err != nil || bearing < 0 || bearing >= 360 {
+			return "", searchOptions{}, errors.New("Query parameter 'bearing' must be a number of degrees from 0 up to 360")
+		}
+		opts.Bearing, opts.BearingTolerance = bearing, defaultBearingTolerance
+		if raw := params.Get("bearing_tolerance"); raw != "" {
+			tolerance, err := strconv.ParseFloat(raw, 64)
+			if err != nil || tolerance <= 0 || tolerance > 180 {
+				return "", searchOptions{}, errors.New("Query parameter 'bearing_tolerance' must be a number of degrees above 0 and at most 180")
+			}
+			opts.BearingTolerance = tolerance
+		}
+		if opts.Near != "" {
+			return "", searchOptions{}, errors.New("Query parameter 'bearing' cannot be combined with 'near'")
+		}
+	} else if params.Get("bearing_tolerance") != "" {
+		return "", searchOptions{}, errors.New("Query parameter 'bearing_tolerance' requires 'bearing'")
+	}
+	switch params.Get("fallback") {
+	case "":
+	case "nearest":
+		opts.FallbackNearest = true
+	default:
+		return "", searchOptions{}, errors.New("Query parameter 'fallback' must be 'nearest'")
+	}
+	opts.ZeroDistance = zeroDistanceMode
+	if mode := params.Get("zero_distance"); mode != "" {
+		if !slices.Contains(zeroDistanceModes, mode) {
+			return "", searchOptions{}, errors.New("Query parameter 'zero_distance' must be one of " + strings.Join(zeroDistanceModes, ", "))
+		}
+		opts.ZeroDistance = mode
+	}
+	if raw := params.Get("exclude"); raw != "" {
+		var names []string
+		for _, name := range strings.Split(raw, ",") {
+			if name = NormalizeQuery(name); name != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		opts.Exclude = strings.Join(names, ",")
+	}
+	if raw := NormalizeQuery(params.Get("name")); raw != "" {
+		opts.Name = raw
+		opts.NameMatch = params.Get("name_match")
+		if opts.NameMatch == "" {
+			opts.NameMatch = "contains"
+		}
+		if !slices.Contains(nameMatchModes, opts.NameMatch) {
+			return "", searchOptions{}, errors.New("Query parameter 'name_match' must be one of " + strings.Join(nameMatchModes, ", "))
+		}
+	}
+	unitKm := 1.0
+	if unit := params.Get("unit"); unit != "" {
+		size, exists := distanceUnits[unit]
+		if !exists {
+			return "", searchOptions{}, errors.New("Query parameter 'unit' must be km, mi or m")
+		}
+		opts.Unit, unitKm = unit, size
+	}
+	if raw := params.Get("precision"); raw != "" {
+		precision, err := strconv.Atoi(raw)
+		if err != nil || precision < 0 || precision > maxDistancePrecision {
+			return "", searchOptions{}, fmt.Errorf("Query parameter 'precision' must be a number of decimals from 0 to %d", maxDistancePrecision)
+		}
+		if opts.Unit == "" {
+			opts.Unit = "km"
+		}
+		opts.Precision = precision
+	} else if opts.Unit != "" {
+		opts.Precision = distancePrecision[opts.Unit]
+	}
+	// The radius is given in the requested unit, kilometers by default.
+	if raw := params.Get("radius"); raw != "" {
+		radius, err := strconv.ParseFloat(raw, 64)
+		if err != nil || radius <= 0 || radius*unitKm > maxRadiusKm {
+			return "", searchOptions{}, fmt.Errorf("Query parameter 'radius' must be a number above 0 and at most %g", maxRadiusKm/unitKm)
+		}
+		opts.RadiusKm = radius * unitKm
+	}
+	if raw := params.Get("radii"); raw != "" {
+		if opts.RadiusKm != 0 {
+			return "", searchOptions{}, errors.New("Query parameters 'radius' and 'radii' cannot be combined")
+		}
+		parts := strings.Split(raw, ",")
+		if len(parts) > maxRings {
+			return "", searchOptions{}, fmt.Errorf("Query parameter 'radii' may list at most %d radii", maxRings)
+		}
+		radii := make([]string, len(parts))
+		previous := 0.0
+		for i, part := range parts {
+			radius, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil || radius <= previous || radius*unitKm > maxRadiusKm {
+				return "", searchOptions{}, fmt.Errorf("Query parameter 'radii' must be ascending numbers above 0 and at most %g", maxRadiusKm/unitKm)
+			}
+			previous = radius
+			radii[i] = strconv.FormatFloat(radius*unitKm, 'g', -1, 64)
+		}
+		opts.Radii = strings.Join(radii, ",")
+		opts.RadiusKm = previous * unitKm
+	}
+	if raw := params.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return "", searchOptions{}, errors.New("Query parameter 'limit' must be a positive integer")
+		}
+		opts.Limit = limit
+	}
+	if raw := params.Get("window"); raw != "" {
+		first, last, ok := strings.Cut(raw, ":")
+		start, startErr := strconv.Atoi(strings.TrimSpace(first))
+		end, endErr := strconv.Atoi(strings.TrimSpace(last))
+		if !ok || startErr != nil || endErr != nil || start < 1 || end < start {
+			return "", searchOptions{}, errors.New("Query parameter 'window' must be start:end with 1 <= start <= end")
+		}
+		if opts.Limit > 0 {
+			return "", searchOptions{}, errors.New("Query parameter 'window' cannot be combined with 'limit'")
+		}
+		opts.WindowStart, opts.WindowEnd = start, end
+	}
+	cursor := params.Get("cursor")
+	if raw := params.Get("page_size"); raw != "" || cursor != "" {
+		opts.PageSize = defaultPageSize
+		if raw != "" {
+			size, err := strconv.Atoi(raw)
+			if err != nil || size < 1 || size > maxResults {
+				return "", searchOptions{}, fmt.Errorf("Query parameter 'page_size' must be a number from 1 to %d", maxResults)
+			}
+			opts.PageSize = size
+		}
+		if cursor != "" {
+			if _, err := decodeCursor(cursor); err != nil {
+				return "", searchOptions{}, err
+			}
+			opts.Cursor = cursor
+		}
+		switch {
+		case opts.Limit > 0 || opts.WindowStart > 0:
+			return "", searchOptions{}, errors.New("Query parameters 'cursor' and 'page_size' cannot be combined with 'limit' or 'window'")
+		case opts.Sort == "name" || opts.Sort == "duration" || opts.SecondarySort != "" || opts.NameMatch == "fuzzy":
+			return "", searchOptions{}, errors.New("Query parameters 'cursor' and 'page_size' page in distance order and cannot be combined with another sort")
+		}
+	}
+	if raw := params.Get("month"); raw != "" {
+		month, err := strconv.Atoi(raw)
+		if err != nil || month < 1 || month > 12 {
+			return "", searchOptions{}, errors.New("Query parameter 'month' must be a number from 1 to 12")
+		}
+		opts.Month = time.Month(month)
+	}
+	return query, opts, nil
 }
 
 func searchHandler(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
+	w = newResponseTimer(w)
+	params := r.URL.Query()
+	// session=new starts a session; a returned token used without a
+	// location searches from the session's last location again.
+	session := params.Get("session")
+	if session != "" && session != "new" {
+		location, exists := loadSessionLocation(session)
+		if !exists {
+			http.Error(w, "Query parameter 'session' is unknown or expired", http.StatusBadRequest)
+			return
+		}
+		if !hasLocation(params) {
+			for name, value := range location {
+				params.Set(name, value)
+			}
+		}
+	}
+	query, opts, err := parseSearchParams(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "geojson" {
+		http.Error(w, "Query parameter 'format' must be json or geojson", http.StatusBadRequest)
 		return
 	}
+	envelope := true
+	if raw := r.URL.Query().Get("envelope"); raw != "" {
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			http.Error(w, "Query parameter 'envelope' must be a boolean", http.StatusBadRequest)
+			return
+		}
+		envelope = value
+	}
+	stream := false
+	if raw := r.URL.Query().Get("stream"); raw != "" {
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			http.Error(w, "Query parameter 'stream' must be a boolean", http.StatusBadRequest)
+			return
+		}
+		stream = value
+	}
+	if stream && format == "geojson" {
+		http.Error(w, "Query parameter 'stream' cannot be combined with format=geojson", http.StatusBadRequest)
+		return
+	}
+
+	opts.Lang = negotiateLanguage(r.Header.Get("Accept-Language"))
+	w.Header().Set("Content-Language", opts.Lang)
+	w.Header().Add("Vary", "Accept-Language")
+
+	if raw := r.URL.Query().Get("explain"); raw != "" {
+		explain, err := strconv.ParseBool(raw)
+		if err != nil {
+			http.Error(w, "Query parameter 'explain' must be a boolean", http.StatusBadRequest)
+			return
+		}
+		if explain {
+			w.Header().Set("Cache-Control", "no-store")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(explainSearch(query, opts))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), searchBudget)
+	defer cancel()
+
+	response, err := paginate(searchProperties(ctx, query, opts), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if session != "" && !response.Unrecognized {
+		if session == "new" {
+			session = newSessionToken()
+		}
+		saveSessionLocation(session, params)
+		response.Session = session
+		w.Header().Set("X-Session", session)
+	}
+	setCacheControl(w, response)
+	if stream {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if !opts.OmitMessage {
+			w.Header().Set("X-Message", response.Message)
+		}
+		writeNDJSON(w, response.Properties)
+		return
+	}
+	switch {
+	case format == "geojson":
+		writeWithETag(w, r, "application/geo+json", toGeoJSON(response.Properties))
+	case !envelope:
+		if !opts.OmitMessage {
+			w.Header().Set("X-Message", response.Message)
+		}
+		writeWithETag(w, r, "application/json", response.Properties)
+	default:
+		writeWithETag(w, r, "application/json", response)
+	}
+}
+
+// writeWithETag encodes v as JSON and tags it with a hash of the body and
+// X-Message, answering 304 when the client already has that version. The
+// tag is weak because gzipResponses may re-encode the body; only the
+// content it describes matters for revalidation.
+func writeWithETag(w http.ResponseWriter, r *http.Request, contentType string, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Encoding response failed", http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+	hash := sha256.New()
+	hash.Write(body)
+	hash.Write([]byte(w.Header().Get("X-Message")))
+	etag := fmt.Sprintf(`W/"%x"`, hash.Sum(nil)[:12])
+
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+// etagMatches applies the weak comparison If-None-Match calls for.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ndjsonFlushEvery is how many lines writeNDJSON sends per flush, trading
+// latency to the first result against per-write overhead.
+const ndjsonFlushEvery = 100
+
+// writeNDJSON writes one property per line, flushing as it goes so clients
+// can process the first results before the last are sent.
+func writeNDJSON(w http.ResponseWriter, results []PropertyResponse) {
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	for i, result := range results {
+		if err := encoder.Encode(result); err != nil {
+			return
+		}
+		if flusher != nil && (i+1)%ndjsonFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+}
 
-	response := searchProperties(query)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// setCacheControl lets browsers and CDNs reuse search results. Unrecognized
+// locations and results cut short by the search budget (truncated without a
+// total) are not cached, since the same request may succeed later.
+//
+// Shared caches key on the full URL, so every query parameter already
+// selects its own variant; Vary lists the headers that also change the
+// response (Accept-Encoding is added by gzipResponses). When API keys are
+// required responses are private, since a shared cache would otherwise
+// serve them to callers without a key. Responses carrying a session token
+// are never stored.
+func setCacheControl(w http.ResponseWriter, response SearchResponse) {
+	if response.Session != "" {
+		w.Header().Set("Cache-Control", "no-store")
+		return
+	}
+	if response.Unrecognized || (response.Truncated && response.Total == 0) || cacheMaxAge <= 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	scope := "public"
+	if requireAPIKeys {
+		scope = "private"
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(cacheMaxAge.Seconds())))
 }
 
 func main() {
-	r := mux.NewRouter()
-	r.HandleFunc("/search", searchHandler).Methods("GET")
+	flag.IntVar(&maxConcurrentSearches, "max-concurrent", maxConcurrentSearches, "maximum number of searches processed at the same time")
+	flag.Float64Var(&matchWeights.Prefix, "match-prefix-weight", matchWeights.Prefix, "weight of prefix matches when scoring fuzzy city matches")
+	flag.Float64Var(&matchWeights.Token, "match-token-weight", matchWeights.Token, "weight of shared words when scoring fuzzy city matches")
+	flag.Float64Var(&matchWeights.Edit, "match-edit-weight", matchWeights.Edit, "weight of edit distance when scoring fuzzy city matches")
+	flag.Float64Var(&earthRadiusKm, "earth-radius-km", earthRadiusKm, "earth radius used for great-circle distances (6371 mean, 6378.137 equatorial)")
+	flag.StringVar(&tlsCertFile, "tls-cert", "", "path to a TLS certificate; serves HTTPS when set together with -tls-key")
+	flag.StringVar(&tlsKeyFile, "tls-key", "", "path to the TLS private key matching -tls-cert")
+	flag.StringVar(&aliasesFile, "aliases-file", "", "JSON object mapping city aliases to cityCenters keys, replacing the built-in aliases")
+	flag.StringVar(&airportsFile, "airports-file", "", "JSON file of airport code coordinates to use instead of the bundled table")
+	flag.StringVar(&pinCodesFile, "pin-file", "", "JSON file of PIN code coordinates to use instead of the bundled table")
+	flag.IntVar(&gzipMinBytes, "gzip-min-bytes", gzipMinBytes, "smallest response body, in bytes, that is gzip-compressed")
+	flag.StringVar(&startupCheckMode, "startup-checks", startupCheckMode, "what to do when the cache or geocoder is unreachable at startup: fail, degrade (serve, with /readyz failing) or off")
+	flag.DurationVar(&startupCheckTimeout, "startup-check-timeout", startupCheckTimeout, "how long startup waits for dependencies to become reachable")
+	flag.DurationVar(&dependencyCheckTimeout, "dependency-check-timeout", dependencyCheckTimeout, "time limit of each dependency check attempt")
+	flag.DurationVar(&readinessTTL, "readiness-ttl", readinessTTL, "how long /readyz reuses a dependency check result before checking again")
+	flag.BoolVar(&strictLocations, "strict-locations", false, "refuse city, airport and PIN code files that give one name conflicting coordinates or have invalid entries, instead of keeping the last and skipping the invalid")
+	flag.StringVar(&accessLogFormat, "access-log", accessLogFormat, "access log format written to stdout: json, common, combined or off")
+	flag.IntVar(&searchCacheGuard.Limit, "cache-max-inserts", 0, "search cache insertions per -cache-insert-window beyond which new results are not cached until the rate subsides (0 for no limit)")
+	flag.DurationVar(&searchCacheGuard.Window, "cache-insert-window", searchCacheGuard.Window, "window over which -cache-max-inserts is counted")
+	flag.StringVar(&cacheBackend, "cache", cacheBackend, "search cache backend: memory or redis")
+	flag.StringVar(&redisAddr, "redis-addr", redisAddr, "address of the Redis server used by -cache=redis")
+	flag.StringVar(&redisPassword, "redis-password", "", "password for the Redis server used by -cache=redis")
+	flag.DurationVar(&redisTTL, "redis-ttl", redisTTL, "expiry of entries in the Redis cache")
+	flag.DurationVar(&searchBudget, "search-budget", searchBudget, "time a search may take before partial results are returned; keep below the 2s write timeout")
+	flag.BoolVar(&exactOnlyMatching, "exact-only", false, "disable fuzzy matching so only exact city and property names resolve")
+	flag.IntVar(&maxResults, "max-results", maxResults, "hard cap on the number of properties in a search response")
+	flag.Float64Var(&clusterDistanceKm, "cluster-km", clusterDistanceKm, "default distance within which properties are grouped by /properties/grouped")
+	flag.StringVar(&geocoderURL, "geocoder-url", "", "URL template of an external geocoder, with {q} standing for the query; disabled when empty")
+	flag.DurationVar(&geocodeBackoff.Initial, "geocoder-backoff", geocodeBackoff.Initial, "how long a query is not re-geocoded after its first failure")
+	flag.DurationVar(&geocodeBackoff.Max, "geocoder-backoff-max", geocodeBackoff.Max, "upper bound of the doubling geocoder failure backoff")
+	flag.DurationVar(&geocodeRetry.Timeout, "geocoder-timeout", geocodeRetry.Timeout, "time limit of each geocoder call")
+	flag.IntVar(&geocodeRetry.Retries, "geocoder-retries", geocodeRetry.Retries, "times a failed geocoder call is retried within one search")
+	flag.DurationVar(&geocodeRetry.Backoff, "geocoder-retry-backoff", geocodeRetry.Backoff, "upper bound of the jittered wait before the first geocoder retry, doubling per retry")
+	flag.IntVar(&geocodeBreaker.Threshold, "geocoder-breaker-failures", geocodeBreaker.Threshold, "consecutive geocoder failures that open its circuit breaker")
+	flag.StringVar(&travelTimeURL, "travel-time-url", "", "URL template of a driving time provider for sort=duration, with {from} and {to} standing for the origin and destinations; disabled when empty")
+	flag.StringVar(&regionURL, "region-url", "", "URL template of a reverse geocoder for enrich=true, with {lat} and {lon} placeholders; disabled when empty")
+	flag.DurationVar(&regionTimeout, "region-timeout", regionTimeout, "time limit of the region lookups of one search")
+	flag.DurationVar(&travelTimeTimeout, "travel-time-timeout", travelTimeTimeout, "time limit of each travel time provider call")
+	flag.DurationVar(&geocodeBreaker.Cooldown, "geocoder-breaker-cooldown", geocodeBreaker.Cooldown, "how long the open geocoder circuit breaker waits before probing again")
+	flag.StringVar(&propertiesFile, "properties-file", "", "JSON file of properties to serve instead of the built-in catalog")
+	flag.StringVar(&validationMode, "validation", validationMode, "how invalid entries in -properties-file are handled: strict rejects the file, lenient skips them")
+	flag.IntVar(&maxMatrixSize, "matrix-max", maxMatrixSize, "largest number of properties /matrix computes distances between")
+	flag.IntVar(&dailyQuotaLimit, "daily-quota", 0, "requests each API key or IP may make per UTC day; 0 disables the quota")
+	flag.BoolVar(&requireAPIKeys, "require-api-key", false, "reject API requests without a valid key from API_KEYS or -api-keys-file")
+	flag.StringVar(&apiKeysFile, "api-keys-file", "", "file of accepted API keys, one per line")
+	flag.Var(&plausibleBounds, "bounds", "minLat,maxLat,minLon,maxLon box that property coordinates are expected in")
+	flag.DurationVar(&cacheMaxAge, "cache-max-age", cacheMaxAge, "Cache-Control max-age advertised on successful searches; 0 disables client caching")
+	flag.Float64Var(&maxRadiusKm, "max-radius", maxRadiusKm, "largest radius, in kilometers, a search may ask for")
+	flag.IntVar(&autoExpandMin, "auto-expand-min", autoExpandMin, "properties an auto_expand=true search wants before it stops doubling the radius")
+	flag.IntVar(&maxAutoExpansions, "auto-expand-max-steps", maxAutoExpansions, "most times an auto_expand=true search doubles its radius")
+	flag.Var(fuzzyPool, "fuzzy-pool", "comma-separated names fuzzy matching considers: cities, aliases, properties")
+	flag.IntVar(&fuzzyFallbackMin, "fuzzy-fallback-min", fuzzyFallbackMin, "fall back to the best fuzzy alternative when an exact match finds fewer properties than this within the radius, after any auto_expand (0 disables)")
+	flag.IntVar(&maxFuzzyCandidates, "fuzzy-max-candidates", maxFuzzyCandidates, "most names fuzzy matching scores per query after pre-filtering, nearest in length first (0 for no limit)")
+	flag.StringVar(&messagesFile, "messages-file", "", "JSON object of language to message key to template, merged over the built-in English and Hindi messages")
+	flag.StringVar(&editDistanceName, "edit-distance", "levenshtein", "edit distance used by fuzzy matching: levenshtein, or damerau to count adjacent transpositions as one edit")
+	flag.StringVar(&adminToken, "admin-token", "", "token required in X-Admin-Token by /admin endpoints; defaults to ADMIN_TOKEN, and the endpoints are disabled without one")
+	flag.DurationVar(&hitRatioWindow, "hit-ratio-window", hitRatioWindow, "window of the rolling cache hit ratio reported by /stats and /metrics")
+	flag.Int64Var(&maxBodyBytes, "max-body-bytes", maxBodyBytes, "largest request body, in bytes, accepted by POST endpoints")
+	flag.IntVar(&maxBatchSize, "batch-max", maxBatchSize, "largest number of searches in one /search/batch request")
+	flag.DurationVar(&sessionTTL, "session-ttl", sessionTTL, "how long an unused search session remembers its location")
+	flag.StringVar(&emptyQueryMode, "empty-query", emptyQueryMode, "handling of searches without q, pin, airport or near: error, all or default-origin")
+	flag.Var(&defaultOrigin, "default-origin", "lat,lon that -empty-query=all and default-origin search from; all falls back to the catalog's centroid when unset")
+	flag.StringVar(&zeroDistanceMode, "zero-distance", zeroDistanceMode, "default handling of properties at the search origin: keep, first, exclude or label")
+	flag.StringVar(&citiesFile, "cities-file", "", "JSON array of {name, lat, lon} city centers to add to or override the built-in ones")
+	flag.StringVar(&mapURLTemplate, "map-url-template", "", "URL template of a static map service for maps=true, with {lat}, {lon} and {zoom} placeholders; disabled when empty")
+	flag.IntVar(&mapZoom, "map-zoom", mapZoom, "zoom level substituted for {zoom} in -map-url-template")
+	flag.IntVar(&coordinatePrecision, "coordinate-precision", coordinatePrecision, "decimals of property coordinates in responses, truncated; -1 for full precision")
+	flag.IntVar(&maxCountedPlaces, "stats-max-places", maxCountedPlaces, "distinct searched places /stats counts before lumping the rest together")
+	flag.Float64Var(&labelMetersBelowKm, "label-meters-below-km", labelMetersBelowKm, "distance in km under which distance labels are given in meters")
+	flag.Var(distancePrecision, "distance-precision", "default decimals of distances per unit, as unit=decimals pairs such as km=2,mi=1,m=0")
+	flag.StringVar(&basePath, "base-path", "", "path prefix of every route, such as /api/v1, for serving behind a reverse proxy")
+	flag.StringVar(&healthCheckQuery, "health-query", healthCheckQuery, "location searched by /healthz?deep=true")
+	flag.StringVar(&healthCheckExpected, "health-expect", healthCheckExpected, "property /healthz?deep=true expects among the results for -health-query")
+	flag.Float64Var(&healthCheckMaxKm, "health-max-km", healthCheckMaxKm, "farthest, in km, -health-expect may be from -health-query for /healthz?deep=true to pass")
+	flag.BoolVar(&probesAtRoot, "probes-at-root", false, "with -base-path, also serve /healthz and /metrics without the prefix")
+	flag.Parse()
+
+	if maxConcurrentSearches < 1 {
+		log.Fatal("-max-concurrent must be at least 1")
+	}
+	if maxResults < 1 {
+		log.Fatal("-max-results must be at least 1")
+	}
+	if healthCheckMaxKm <= 0 {
+		log.Fatal("-health-max-km must be positive")
+	}
+	if earthRadiusKm <= 0 {
+		log.Fatal("-earth-radius-km must be positive")
+	}
+	if validationMode != "strict" && validationMode != "lenient" {
+		log.Fatalf("Unknown -validation mode %q", validationMode)
+	}
+	if maxBodyBytes < 1 || maxBatchSize < 1 {
+		log.Fatal("-max-body-bytes and -batch-max must be at least 1")
+	}
+	if geocodeBreaker.Threshold < 1 {
+		log.Fatal("-geocoder-breaker-failures must be at least 1")
+	}
+	if !slices.Contains(zeroDistanceModes, zeroDistanceMode) {
+		log.Fatalf("Unknown -zero-distance mode %q", zeroDistanceMode)
+	}
+	if !slices.Contains(emptyQueryModes, emptyQueryMode) {
+		log.Fatalf("Unknown -empty-query mode %q", emptyQueryMode)
+	}
+	if defaultOrigin.Coordinates == nil && emptyQueryMode == "default-origin" {
+		log.Fatal("-empty-query=default-origin requires -default-origin")
+	}
+	basePath = strings.TrimSuffix(basePath, "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		log.Fatal("-base-path must start with /")
+	}
+	if fuzzyFallbackMin < 0 {
+		log.Fatal("-fuzzy-fallback-min must not be negative")
+	}
+	if maxFuzzyCandidates < 0 {
+		log.Fatal("-fuzzy-max-candidates must not be negative")
+	}
+	if hitRatioWindow <= 0 {
+		log.Fatal("-hit-ratio-window must be positive")
+	}
+	distance, exists := editDistances[editDistanceName]
+	if !exists {
+		log.Fatalf("Unknown -edit-distance %q", editDistanceName)
+	}
+	editDistance = distance
+	if coordinatePrecision < -1 || coordinatePrecision > maxCoordinatePrecision {
+		log.Fatalf("-coordinate-precision must be -1 or from 0 to %d", maxCoordinatePrecision)
+	}
+	if autoExpandMin < 1 || maxAutoExpansions < 0 {
+		log.Fatal("-auto-expand-min must be at least 1 and -auto-expand-max-steps not negative")
+	}
+	if mapZoom < 0 {
+		log.Fatal("-map-zoom must not be negative")
+	}
+	if maxCountedPlaces < 0 {
+		log.Fatal("-stats-max-places must not be negative")
+	}
+	if labelMetersBelowKm < 0 {
+		log.Fatal("-label-meters-below-km must not be negative")
+	}
+	if regionTimeout <= 0 {
+		log.Fatal("-region-timeout must be positive")
+	}
+	if travelTimeTimeout <= 0 {
+		log.Fatal("-travel-time-timeout must be positive")
+	}
+	if geocodeRetry.Timeout <= 0 || geocodeRetry.Retries < 0 || geocodeRetry.Backoff < 0 {
+		log.Fatal("-geocoder-timeout must be positive and -geocoder-retries and -geocoder-retry-backoff not negative")
+	}
+	if searchCacheGuard.Limit < 0 || searchCacheGuard.Window <= 0 {
+		log.Fatal("-cache-max-inserts must not be negative and -cache-insert-window must be positive")
+	}
+	if !slices.Contains(accessLogFormats, accessLogFormat) {
+		log.Fatalf("Unknown -access-log %q, want one of json, common, combined, off", accessLogFormat)
+	}
+	if !slices.Contains(startupCheckModes, startupCheckMode) {
+		log.Fatalf("Unknown -startup-checks %q, want one of fail, degrade, off", startupCheckMode)
+	}
+	if startupCheckTimeout < 0 || dependencyCheckTimeout <= 0 {
+		log.Fatal("-startup-check-timeout must not be negative and -dependency-check-timeout must be positive")
+	}
+	if readinessTTL < 0 {
+		log.Fatal("-readiness-ttl must not be negative")
+	}
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		log.Fatal("-tls-cert and -tls-key must be set together")
+	}
+
+	if raw := os.Getenv("SLOW_THRESHOLD_MS"); raw != "" {
+		ms, err := strconv.Atoi(raw)
+		if err != nil || ms < 0 {
+			log.Fatalf("SLOW_THRESHOLD_MS must be a non-negative number of milliseconds, got %q", raw)
+		}
+		slowSearchThreshold = time.Duration(ms) * time.Millisecond
+	}
+	switch level := strings.ToLower(os.Getenv("LOG_LEVEL")); level {
+	case "", "info":
+	case "debug":
+		debugLogging = true
+	default:
+		log.Fatalf("LOG_LEVEL must be debug or info, got %q", level)
+	}
+
+	switch cacheBackend {
+	case "memory":
+	case "redis":
+		searchCache = newRedisCache(redisAddr, redisPassword, redisTTL)
+		log.Printf("Using Redis cache at %s", redisAddr)
+	default:
+		log.Fatalf("Unknown -cache backend %q", cacheBackend)
+	}
+
+	if citiesFile != "" {
+		table, err := loadLocationTable(citiesFile, NormalizeQuery)
+		if err != nil {
+			log.Fatalf("Loading cities: %v", err)
+		}
+		for city, coords := range table {
+			cityCenters[city] = coords
+		}
+		resetFuzzyIndex()
+		log.Printf("Loaded %d cities from %s", len(table), citiesFile)
+	}
+
+	if propertiesFile != "" {
+		if err := reloadCatalog(); err != nil {
+			log.Fatalf("Loading properties: %v", err)
+		}
+		go reloadCatalogOnSIGHUP()
+	} else {
+		setCatalog(properties, 0)
+	}
+
+	if geocoderURL != "" {
+		geocoder = newHTTPGeocoder(geocoderURL)
+		log.Printf("Geocoding unknown places with %s", geocoderURL)
+	}
+	if regionURL != "" {
+		regionResolver = newHTTPRegionResolver(regionURL)
+		log.Printf("Resolving property regions with %s", regionURL)
+	}
+	if travelTimeURL != "" {
+		travelTimer = newHTTPTravelTimer(travelTimeURL)
+		log.Printf("Estimating travel times with %s", travelTimeURL)
+	}
+
+	if messagesFile != "" {
+		if err := loadMessages(messagesFile); err != nil {
+			log.Fatalf("Loading messages: %v", err)
+		}
+		log.Printf("Loaded messages from %s", messagesFile)
+	}
+
+	if aliasesFile != "" {
+		aliases, err := loadAliases(aliasesFile)
+		if err != nil {
+			log.Fatalf("Loading aliases: %v", err)
+		}
+		cityAliases = aliases
+		resetFuzzyIndex()
+		log.Printf("Loaded %d city aliases from %s", len(cityAliases), aliasesFile)
+	}
+
+	if airportsFile != "" {
+		table, err := loadLocationTable(airportsFile, strings.ToUpper)
+		if err != nil {
+			log.Fatalf("Loading airports: %v", err)
+		}
+		airportCodes = table
+		log.Printf("Loaded %d airports from %s", len(airportCodes), airportsFile)
+	}
+
+	if pinCodesFile != "" {
+		table, err := loadLocationTable(pinCodesFile, strings.TrimSpace)
+		if err != nil {
+			log.Fatalf("Loading PIN codes: %v", err)
+		}
+		pinCodes = table
+		log.Printf("Loaded %d PIN codes from %s", len(pinCodes), pinCodesFile)
+	}
+
+	if basePath != "" {
+		log.Printf("Serving the API under %s", basePath)
+	}
+	var apiKeys map[string]bool
+	if requireAPIKeys {
+		keys, err := loadAPIKeys(apiKeysFile)
+		if err != nil {
+			log.Fatalf("Loading API keys: %v", err)
+		}
+		if len(keys) == 0 {
+			log.Fatal("-require-api-key is set but no keys were found in API_KEYS or -api-keys-file")
+		}
+		apiKeys = keys
+		log.Printf("Requiring one of %d API keys", len(apiKeys))
+	}
+
+	if startupCheckMode != "off" && !waitForDependencies() {
+		if startupCheckMode == "fail" {
+			log.Fatal("Dependencies are unavailable, exiting")
+		}
+		log.Print("Starting degraded until dependencies recover")
+	}
+
+	if adminToken == "" {
+		adminToken = os.Getenv("ADMIN_TOKEN")
+	}
+	router := newRouter(routerConfig{
+		BasePath:     basePath,
+		ProbesAtRoot: probesAtRoot,
+		APIKeys:      apiKeys,
+		AdminToken:   adminToken,
+	})
 
 	srv := &http.Server{
-		Handler:      r,
+		Handler:      recoverPanics(accessLog(accessLogFormat, gzipResponses(gzipMinBytes, router))),
 		Addr:         ":8080",
 		WriteTimeout: 2 * time.Second,
 		ReadTimeout:  1 * time.Second,
 	}
 
-	log.Println("Starting server on :8080")
+	if tlsCertFile != "" {
+		log.Println("Starting server on :8080 with TLS enabled")
+		log.Fatal(srv.ListenAndServeTLS(tlsCertFile, tlsKeyFile))
+	}
+	log.Println("Starting server on :8080 with TLS disabled")
 	log.Fatal(srv.ListenAndServe())
 }
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withFreshCache gives a test its own empty search cache, so results cached
+// by other tests under different settings cannot leak into it.
+func withFreshCache(t testing.TB) {
+	t.Helper()
+	previous := searchCache
+	searchCache = newMemoryCache()
+	t.Cleanup(func() { searchCache = previous })
+}
+
+// getSearch runs GET /search?rawQuery through searchHandler.
+func getSearch(t *testing.T, rawQuery string) (*httptest.ResponseRecorder, SearchResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	searchHandler(rec, httptest.NewRequest("GET", "/search?"+rawQuery, nil))
+	var response SearchResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+		}
+	}
+	return rec, response
+}
+
+func TestExactOnlyMatching(t *testing.T) {
+	tests := []struct {
+		name      string
+		flag      bool
+		rawQuery  string
+		wantFound bool
+	}{
+		{"fuzzy by default", false, "q=udaipr", true},
+		{"exact hit by default", false, "q=udaipur", true},
+		{"exact param", false, "q=udaipr&exact=true", false},
+		{"exact param hit", false, "q=udaipur&exact=true", true},
+		{"exact flag", true, "q=udaipr", false},
+		{"exact flag hit", true, "q=Udaipur", true},
+		{"param cannot undo flag", true, "q=udaipr&exact=false", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFreshCache(t)
+			previous := exactOnlyMatching
+			exactOnlyMatching = tt.flag
+			t.Cleanup(func() { exactOnlyMatching = previous })
+
+			rec, response := getSearch(t, tt.rawQuery)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+			}
+			found := response.Message != "Location not recognized"
+			if found != tt.wantFound {
+				t.Errorf("found = %v (%q), want %v", found, response.Message, tt.wantFound)
+			}
+		})
+	}
+}
+
+// resultNames lists the names of response's properties in order.
+func resultNames(response SearchResponse) []string {
+	names := []string{}
+	for _, prop := range response.Properties {
+		names = append(names, prop.Name)
+	}
+	return names
+}
+
+func TestSearchExclude(t *testing.T) {
+	withCatalog(t, []Property{
+		{Name: "Lake Stay", Latitude: 24.58, Longitude: 73.68},
+		{Name: "Hill Stay", Latitude: 24.60, Longitude: 73.70},
+		{Name: "Café Stay", Latitude: 24.59, Longitude: 73.71},
+	})
+	tests := []struct {
+		exclude string
+		want    []string
+	}{
+		{"", []string{"Lake Stay", "Hill Stay", "Café Stay"}},
+		{"Lake Stay", []string{"Hill Stay", "Café Stay"}},
+		{" HILL   stay ,lake stay", []string{"Café Stay"}},
+		{"cafe stay", []string{"Lake Stay", "Hill Stay"}},
+		{"Nowhere Stay,,", []string{"Lake Stay", "Hill Stay", "Café Stay"}},
+	}
+	for _, tt := range tests {
+		withFreshCache(t)
+		rec, response := getSearch(t, "q=udaipur&exclude="+url.QueryEscape(tt.exclude))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("exclude=%q: status %d: %s", tt.exclude, rec.Code, rec.Body.String())
+		}
+		got := resultNames(response)
+		slices.Sort(got)
+		want := slices.Clone(tt.want)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("exclude=%q: got %v, want %v", tt.exclude, got, want)
+		}
+	}
+}
+
+func TestWithinRadius(t *testing.T) {
+	tests := []struct {
+		name      string
+		distance  float64
+		exclusive bool
+		want      bool
+	}{
+		{"inside inclusive", 9.9, false, true},
+		{"inside exclusive", 9.9, true, true},
+		{"on boundary inclusive", 10, false, true},
+		{"on boundary exclusive", 10, true, false},
+		{"rounding above boundary inclusive", 10 + boundaryEpsilonKm/2, false, true},
+		{"rounding below boundary exclusive", 10 - boundaryEpsilonKm/2, true, false},
+		{"outside inclusive", 10.001, false, false},
+	}
+	for _, tt := range tests {
+		if got := withinRadius(tt.distance, 10, tt.exclusive); got != tt.want {
+			t.Errorf("%s: withinRadius(%v, 10, %v) = %v, want %v", tt.name, tt.distance, tt.exclusive, got, tt.want)
+		}
+	}
+}
+
+func TestSearchBoundary(t *testing.T) {
+	center := cityCenters["udaipur"]
+	edge := Property{Name: "Edge Stay", Latitude: 24.70, Longitude: 73.80}
+	withCatalog(t, []Property{{Name: "Inner Stay", Latitude: 24.58, Longitude: 73.70}, edge})
+	radius := strconv.FormatFloat(calculateDistance(center.Lat, center.Lon, edge.Latitude, edge.Longitude), 'f', -1, 64)
+
+	tests := []struct {
+		boundary string
+		want     []string
+	}{
+		{"", []string{"Inner Stay", "Edge Stay"}},
+		{"inclusive", []string{"Inner Stay", "Edge Stay"}},
+		{"exclusive", []string{"Inner Stay"}},
+	}
+	for _, tt := range tests {
+		withFreshCache(t)
+		rec, response := getSearch(t, "q=udaipur&radius="+radius+"&boundary="+tt.boundary)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("boundary=%q: status %d: %s", tt.boundary, rec.Code, rec.Body.String())
+		}
+		if got := resultNames(response); !slices.Equal(got, tt.want) {
+			t.Errorf("boundary=%q: got %v, want %v", tt.boundary, got, tt.want)
+		}
+	}
+
+	if rec, _ := getSearch(t, "q=udaipur&boundary=open"); rec.Code != http.StatusBadRequest {
+		t.Errorf("boundary=open: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSearchInMeters(t *testing.T) {
+	// Lake Stay is 3340.69m from the center of Udaipur.
+	withCatalog(t, []Property{{Name: "Lake Stay", Latitude: 24.58, Longitude: 73.68}})
+	tests := []struct {
+		rawQuery     string
+		wantDistance float64
+		wantMessage  string
+	}{
+		{"unit=m&radius=3400", 3341, "Found 1 properties within 3,400m"},
+		{"unit=m&radius=3300", 0, "No properties found within 3,300m"},
+		{"unit=m&radius=3400.4", 3341, "Found 1 properties within 3,400m"},
+		{"unit=m&radius=3400&precision=1", 3340.7, "Found 1 properties within 3,400m"},
+		{"radius=3.4", 0, "Found 1 properties within 3.4km"},
+	}
+	for _, tt := range tests {
+		withFreshCache(t)
+		rec, response := getSearch(t, "q=udaipur&"+tt.rawQuery)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.rawQuery, rec.Code, rec.Body.String())
+		}
+		if response.Message != tt.wantMessage {
+			t.Errorf("%s: message %q, want %q", tt.rawQuery, response.Message, tt.wantMessage)
+		}
+		if tt.wantDistance == 0 {
+			continue
+		}
+		if len(response.Properties) != 1 || response.Properties[0].UnitDistance == nil {
+			t.Fatalf("%s: got %+v, want Lake Stay with a distance in meters", tt.rawQuery, response.Properties)
+		}
+		if got := *response.Properties[0].UnitDistance; got != tt.wantDistance {
+			t.Errorf("%s: distance %v, want %v", tt.rawQuery, got, tt.wantDistance)
+		}
+	}
+}
+
+func TestSearchZeroDistanceModes(t *testing.T) {
+	// Both twins sit on the center of Udaipur; Stay is a better fuzzy match
+	// for "stay" but not at the origin.
+	center := cityCenters["udaipur"]
+	withCatalog(t, []Property{
+		{Name: "Twin Stai", Latitude: center.Lat, Longitude: center.Lon},
+		{Name: "Twin Staa", Latitude: center.Lat, Longitude: center.Lon},
+		{Name: "Stay", Latitude: 24.62, Longitude: 73.72},
+	})
+	tests := []struct {
+		mode        string
+		want        []string
+		wantLabeled []string
+	}{
+		{"keep", []string{"Stay", "Twin Stai", "Twin Staa"}, nil},
+		{"first", []string{"Twin Stai", "Twin Staa", "Stay"}, nil},
+		{"exclude", []string{"Stay"}, nil},
+		{"label", []string{"Stay", "Twin Stai", "Twin Staa"}, []string{"Twin Stai", "Twin Staa"}},
+	}
+	for _, tt := range tests {
+		withFreshCache(t)
+		rec, response := getSearch(t, "q=udaipur&name=stay&name_match=fuzzy&zero_distance="+tt.mode)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.mode, rec.Code, rec.Body.String())
+		}
+		if got := resultNames(response); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.mode, got, tt.want)
+		}
+		labeled := []string{}
+		for _, prop := range response.Properties {
+			if prop.AtOrigin {
+				labeled = append(labeled, prop.Name)
+			}
+		}
+		if !slices.Equal(labeled, append([]string{}, tt.wantLabeled...)) {
+			t.Errorf("%s: at_origin set on %v, want %v", tt.mode, labeled, tt.wantLabeled)
+		}
+	}
+}
+
+func TestSearchMinRating(t *testing.T) {
+	withCatalog(t, []Property{
+		{Name: "Top Stay", Latitude: 24.58, Longitude: 73.68, Rating: 4.8},
+		{Name: "Good Stay", Latitude: 24.59, Longitude: 73.69, Rating: 4.0},
+		{Name: "Poor Stay", Latitude: 24.60, Longitude: 73.70, Rating: 2.5},
+		{Name: "New Stay", Latitude: 24.61, Longitude: 73.71},
+	})
+	tests := []struct {
+		minRating  string
+		wantStatus int
+		want       []string
+	}{
+		{"", http.StatusOK, []string{"Good Stay", "New Stay", "Poor Stay", "Top Stay"}},
+		{"4", http.StatusOK, []string{"Good Stay", "New Stay", "Top Stay"}},
+		{"4.5", http.StatusOK, []string{"New Stay", "Top Stay"}},
+		{"5", http.StatusOK, []string{"New Stay"}},
+		{"0.5", http.StatusBadRequest, nil},
+		{"6", http.StatusBadRequest, nil},
+		{"good", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		withFreshCache(t)
+		rec, response := getSearch(t, "q=udaipur&min_rating="+tt.minRating)
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("min_rating=%s: status %d, want %d: %s", tt.minRating, rec.Code, tt.wantStatus, rec.Body.String())
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		got := resultNames(response)
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("min_rating=%s: got %v, want %v", tt.minRating, got, tt.want)
+		}
+	}
+}
+
+func TestSearchBlankQuery(t *testing.T) {
+	withFreshCache(t)
+	for _, rawQuery := range []string{"", "q=", "q=%20%20", "q=%09%0A", "q=+&pin=%20"} {
+		rec, _ := getSearch(t, rawQuery)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want %d", rawQuery, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if rec, _ := getSearch(t, "q=%20udaipur%20"); rec.Code != http.StatusOK {
+		t.Errorf("padded query: status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+// slowGeocoder takes delay to answer coords and counts its calls, which may
+// be concurrent.
+type slowGeocoder struct {
+	delay  time.Duration
+	coords Coordinates
+	calls  atomic.Int32
+}
+
+func (g *slowGeocoder) Geocode(ctx context.Context, query string) (Coordinates, error) {
+	g.calls.Add(1)
+	time.Sleep(g.delay)
+	return g.coords, nil
+}
+
+func TestConcurrentMissesComputeOnce(t *testing.T) {
+	withFreshCache(t)
+	withCatalog(t, []Property{{Name: "Beach Stay", Latitude: 15.50, Longitude: 73.83}})
+	stub := &slowGeocoder{delay: 50 * time.Millisecond, coords: Coordinates{Lat: 15.49, Lon: 73.82}}
+	withGeocoder(t, stub)
+	geocodeRetry.Timeout = time.Second
+
+	const searches = 8
+	opts := searchOptions{RadiusKm: defaultRadiusKm}
+	var wg sync.WaitGroup
+	responses := make([]SearchResponse, searches)
+	for i := range searches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = searchProperties(context.Background(), "Panjim", opts)
+		}()
+	}
+	wg.Wait()
+
+	if calls := stub.calls.Load(); calls != 1 {
+		t.Errorf("%d concurrent searches geocoded %d times, want once", searches, calls)
+	}
+	for i, response := range responses {
+		if names := resultNames(response); !slices.Equal(names, []string{"Beach Stay"}) {
+			t.Errorf("search %d got %v, want [Beach Stay]", i, names)
+		}
+	}
+}
+
+func TestSearchWithoutMessage(t *testing.T) {
+	withFreshCache(t)
+	tests := []struct {
+		rawQuery    string
+		wantMessage bool
+	}{
+		{"q=udaipur&message=false", false},
+		{"q=udaipur", true},
+		{"q=udaipur&message=true", true},
+		{"q=udaipur&message=false", false},
+		{"q=atlantis&exact=true&message=false", false},
+	}
+	for _, tt := range tests {
+		rec, _ := getSearch(t, tt.rawQuery)
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &fields); err != nil {
+			t.Fatalf("%s: decoding %s: %v", tt.rawQuery, rec.Body.String(), err)
+		}
+		if _, got := fields["message"]; got != tt.wantMessage {
+			t.Errorf("%s: message present = %v, want %v", tt.rawQuery, got, tt.wantMessage)
+		}
+	}
+	if rec, _ := getSearch(t, "q=udaipur&message=maybe"); rec.Code != http.StatusBadRequest {
+		t.Errorf("message=maybe: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSearchWithoutMessageSharesCache(t *testing.T) {
+	withFreshCache(t)
+	getSearch(t, "q=udaipur&message=false")
+	_, response := getSearch(t, "q=udaipur")
+	if response.Message == "" {
+		t.Error("a search with the message got it dropped by an earlier message=false search")
+	}
+	if entries := len(searchCache.(*memoryCache).entries); entries != 1 {
+		t.Errorf("message=false and the default made %d cache entries, want 1", entries)
+	}
+}
+
+func TestSearchSortByNameIsStable(t *testing.T) {
+	want := []string{"Moustache Udaipur", "Moustache Udaipur Luxuria", "Moustache Udaipur Verandah"}
+	for run := 0; run < 20; run++ {
+		withFreshCache(t)
+		_, response := getSearch(t, "q=udaipur&sort=name")
+		if got := resultNames(response); !slices.Equal(got, want) {
+			t.Fatalf("run %d: got %v, want %v", run, got, want)
+		}
+	}
+}
+
+func TestLessByNameBreaksTies(t *testing.T) {
+	results := []PropertyResponse{
+		{Name: "twin stay", Distance: 1, Latitude: 24.6, Longitude: 73.7},
+		{Name: "Twin Stay", Distance: 2, Latitude: 24.6, Longitude: 73.6},
+		{Name: "Twin Stay", Distance: 1, Latitude: 24.6, Longitude: 73.8},
+		{Name: "Twin Stay", Distance: 1, Latitude: 24.5, Longitude: 73.9},
+		{Name: "Twin Stay", Distance: 1, Latitude: 24.6, Longitude: 73.7},
+		{Name: "Apple Stay", Distance: 9},
+	}
+	want := []PropertyResponse{results[5], results[3], results[4], results[2], results[1], results[0]}
+	for run := 0; run < 20; run++ {
+		shuffled := slices.Clone(results)
+		rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		slices.SortFunc(shuffled, func(a, b PropertyResponse) int {
+			switch {
+			case lessByName(a, b):
+				return -1
+			case lessByName(b, a):
+				return 1
+			}
+			return 0
+		})
+		for i := range want {
+			if shuffled[i].Name != want[i].Name || shuffled[i].Distance != want[i].Distance || shuffled[i].Longitude != want[i].Longitude {
+				t.Fatalf("run %d: position %d is %+v, want %+v", run, i, shuffled[i], want[i])
+			}
+		}
+	}
+}
+
+func TestHealthDeep(t *testing.T) {
+	withFreshCache(t)
+	get := func(rawQuery string) (int, healthResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		healthHandler(rec, httptest.NewRequest("GET", "/healthz?"+rawQuery, nil))
+		var response healthResponse
+		if rec.Code != http.StatusBadRequest {
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("%s: %v", rawQuery, err)
+			}
+		}
+		return rec.Code, response
+	}
+
+	for _, rawQuery := range []string{"", "deep=true", "deep=1", "deep=false"} {
+		if code, response := get(rawQuery); code != http.StatusOK || response.Status != "ok" {
+			t.Errorf("%q with the built-in catalog: %d %+v, want 200 ok", rawQuery, code, response)
+		}
+	}
+	if code, _ := get("deep=maybe"); code != http.StatusBadRequest {
+		t.Errorf("deep=maybe: status %d, want 400", code)
+	}
+
+	// The same listing with latitude and longitude swapped ends up nowhere
+	// near Udaipur, which only a search that really ran can notice.
+	withFreshCache(t)
+	withCatalog(t, []Property{{Name: "Moustache Udaipur", Latitude: 73.7125, Longitude: 24.5854}})
+	if code, response := get("deep=true"); code != http.StatusServiceUnavailable || response.Status != "unhealthy" || response.Reason == "" {
+		t.Errorf("swapped coordinates: %d %+v, want 503 unhealthy with a reason", code, response)
+	}
+	if code, _ := get("deep=false"); code != http.StatusOK {
+		t.Errorf("shallow check with swapped coordinates: status %d, want 200", code)
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/agnivade/levenshtein"
+)
+
+const (
+	maxFuzzyDistance = 1
+	minPrefixLength  = 3
+	maxSuggestions   = 3
+)
+
+// matchWeights controls how much each signal contributes to a candidate's
+// score. Prefix and token matches let partial queries such as "jai" resolve
+// even when they are too far from any city by edit distance alone.
+var matchWeights = struct {
+	Prefix float64
+	Token  float64
+	Edit   float64
+}{Prefix: 1, Token: 1, Edit: 1}
+
+// NormalizeQuery turns a query or name into the form used for matching and
+// cache keys, so exact lookups and the cache always agree on which queries
+// are the same. The default lowercases it, folds accents, trims it and
+// collapses runs of whitespace; deployments can replace it, for example to
+// apply their own aliases, before the server starts.
+var NormalizeQuery = defaultNormalizeQuery
+
+func defaultNormalizeQuery(query string) string {
+	return strings.Join(strings.Fields(foldAccents(strings.ToLower(query))), " ")
+}
+
+// accentFolds maps accented lowercase Latin letters to their base letter.
+var accentFolds = func() map[rune]rune {
+	const accented = "àáâãäåçèéêëìíîïñòóôõöùúûüýÿāăąćĉċčďēĕėęěĝğġģĥĩīĭįĵķĺļľńņňōŏőŕŗřśŝşšţťũūŭůűųŵŷźżžøđħıłŧ"
+	const plain = "aaaaaaceeeeiiiinooooouuuuyyaaaccccdeeeeegggghiiiijklllnnnooorrrssssttuuuuuuwyzzzodhilt"
+	bases := []rune(plain)
+	folds := make(map[rune]rune, len(bases))
+	for i, r := range []rune(accented) {
+		folds[r] = bases[i]
+	}
+	return folds
+}()
+
+// foldAccents strips diacritics from Latin letters, whether precomposed or
+// followed by combining marks, so "Udaipur" also matches "Údaipur". Other
+// scripts are left alone, since their combining marks are vowel signs
+// rather than accents.
+func foldAccents(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '\u0300' && r <= '\u036f' {
+			return -1
+		}
+		if base, ok := accentFolds[r]; ok {
+			return base
+		}
+		return r
+	}, s)
+}
+
+// fuzzyPool selects which names fuzzy matching considers: "cities" (the
+// cityCenters keys), "aliases" (cityAliases keys) and "properties"
+// (property names, so a misspelt stay still resolves to it).
+var fuzzyPool = fuzzyPoolSet{"cities": true}
+
+var fuzzyPoolSources = []string{"cities", "aliases", "properties"}
+
+// fuzzyPoolSet implements flag.Value as a comma-separated list of
+// fuzzyPoolSources.
+type fuzzyPoolSet map[string]bool
+
+func (p fuzzyPoolSet) String() string {
+	var sources []string
+	for _, source := range fuzzyPoolSources {
+		if p[source] {
+			sources = append(sources, source)
+		}
+	}
+	return strings.Join(sources, ",")
+}
+
+func (p fuzzyPoolSet) Set(value string) error {
+	sources := strings.Split(value, ",")
+	for i, source := range sources {
+		sources[i] = strings.TrimSpace(source)
+		if !slices.Contains(fuzzyPoolSources, sources[i]) {
+			return fmt.Errorf("unknown source %q, want one of %s", sources[i], strings.Join(fuzzyPoolSources, ", "))
+		}
+	}
+	clear(p)
+	for _, source := range sources {
+		p[source] = true
+	}
+	return nil
+}
+
+// maxFuzzyCandidates caps how many names findBestMatch scores on length
+// alone; zero means no cap. When there are more, those closest in length to
+// the query are kept. Names starting with the query or sharing a word with
+// it are always scored.
+var maxFuzzyCandidates = 0
+
+// fuzzyEntry is a name fuzzy matching considers and the origin it resolves
+// to.
+type fuzzyEntry struct {
+	name   string
+	length int
+	origin resolvedOrigin
+}
+
+// fuzzyIndex holds the names of fuzzyPool grouped the ways findBestMatch
+// pre-filters them. Edit distance is at least the difference in length, so
+// a name further than maxFuzzyDistance in length from the query can only be
+// accepted by matchScore for starting with the query or sharing a word with
+// it, and findBestMatch looks up just those and the names of nearby lengths.
+// Against 50,000 cities, BenchmarkFindBestMatch has a lookup take about 10ms
+// instead of the 25ms of scoring every name, and 11µs with
+// -fuzzy-max-candidates=20; building the index takes about 50ms.
+type fuzzyIndex struct {
+	entries []fuzzyEntry
+	// byName lists entries in name order, for prefix lookups; the lists of
+	// byLength and byWord keep that order.
+	byName   []int
+	byLength map[int][]int
+	byWord   map[string][]int
+	// catalog is the catalog version the index was built from, or -1 when
+	// fuzzyPool leaves properties out.
+	catalog int
+}
+
+func buildFuzzyIndex(props []Property, catalog int) *fuzzyIndex {
+	index := &fuzzyIndex{
+		entries:  make([]fuzzyEntry, 0, len(cityCenters)+len(cityAliases)+len(props)),
+		byLength: make(map[int][]int),
+		byWord:   make(map[string][]int, len(cityCenters)+len(props)),
+		catalog:  catalog,
+	}
+	add := func(name string, origin resolvedOrigin) {
+		index.entries = append(index.entries, fuzzyEntry{name, utf8.RuneCountInString(name), origin})
+	}
+	if fuzzyPool["cities"] {
+		for city, coords := range cityCenters {
+			add(city, resolvedOrigin{Lat: coords.Lat, Lon: coords.Lon, Label: city, City: city})
+		}
+	}
+	if fuzzyPool["aliases"] {
+		for alias, city := range cityAliases {
+			if coords, exists := cityCenters[city]; exists {
+				add(alias, resolvedOrigin{Lat: coords.Lat, Lon: coords.Lon, Label: city, City: city})
+			}
+		}
+	}
+	for _, prop := range props {
+		origin := resolvedOrigin{Lat: prop.Latitude, Lon: prop.Longitude, Label: prop.Name, FromProperty: true}
+		add(NormalizeQuery(prop.Name), origin)
+		for _, alias := range prop.Aliases {
+			add(NormalizeQuery(alias), origin)
+		}
+	}
+
+	index.byName = make([]int, len(index.entries))
+	for i := range index.byName {
+		index.byName[i] = i
+	}
+	slices.SortFunc(index.byName, func(i, j int) int {
+		return cmp.Or(strings.Compare(index.entries[i].name, index.entries[j].name), i-j)
+	})
+	for _, i := range index.byName {
+		entry := index.entries[i]
+		index.byLength[entry.length] = append(index.byLength[entry.length], i)
+		for _, word := range tokenize(entry.name) {
+			if ids := index.byWord[word]; len(ids) == 0 || ids[len(ids)-1] != i {
+				index.byWord[word] = append(ids, i)
+			}
+		}
+	}
+	return index
+}
+
+// The fuzzy index is rebuilt when the catalog it was built from changes;
+// changes to cityCenters, cityAliases or fuzzyPool must be followed by
+// resetFuzzyIndex.
+var (
+	fuzzyIndexMu sync.Mutex
+	fuzzyNames   *fuzzyIndex
+)
+
+func currentFuzzyIndex() *fuzzyIndex {
+	var props []Property
+	catalog := -1
+	if fuzzyPool["properties"] {
+		catalogMu.RLock()
+		props, catalog = properties, catalogGeneration
+		catalogMu.RUnlock()
+	}
+	fuzzyIndexMu.Lock()
+	defer fuzzyIndexMu.Unlock()
+	if fuzzyNames == nil || fuzzyNames.catalog != catalog {
+		fuzzyNames = buildFuzzyIndex(props, catalog)
+	}
+	return fuzzyNames
+}
+
+func resetFuzzyIndex() {
+	fuzzyIndexMu.Lock()
+	defer fuzzyIndexMu.Unlock()
+	fuzzyNames = nil
+}
+
+// findBestMatch scores query against the names in fuzzyPool that can match
+// it and returns the origin of the best one. Ties go to the alphabetically
+// first name.
+func findBestMatch(query string) (resolvedOrigin, bool) {
+	return findBestMatchExcept(query, "")
+}
+
+// findBestMatchExcept is findBestMatch passing over names whose origin is
+// labelled except, so that an exact match can look for its next best
+// alternative.
+func findBestMatchExcept(query, except string) (resolvedOrigin, bool) {
+	query = NormalizeQuery(query)
+	index := currentFuzzyIndex()
+	skip := func(i int) bool {
+		return except != "" && index.entries[i].origin.Label == except
+	}
+
+	// Names starting with the query or sharing a word with it come first
+	// and are never capped.
+	var candidates []int
+	strong := make(map[int]bool)
+	if len(query) >= minPrefixLength {
+		first := sort.Search(len(index.byName), func(i int) bool {
+			return index.entries[index.byName[i]].name >= query
+		})
+		for _, i := range index.byName[first:] {
+			if !strings.HasPrefix(index.entries[i].name, query) {
+				break
+			}
+			if !skip(i) && !strong[i] {
+				strong[i] = true
+				candidates = append(candidates, i)
+			}
+		}
+	}
+	for _, word := range tokenize(query) {
+		for _, i := range index.byWord[word] {
+			if !skip(i) && !strong[i] {
+				strong[i] = true
+				candidates = append(candidates, i)
+			}
+		}
+	}
+
+	// Then the names of nearby lengths, closest first; each length is in
+	// name order, so stopping at the cap needs no sorting.
+	queryLength := utf8.RuneCountInString(query)
+	banded := 0
+band:
+	for gap := 0; gap <= maxFuzzyDistance; gap++ {
+		for _, length := range []int{queryLength - gap, queryLength + gap} {
+			for _, i := range index.byLength[length] {
+				if maxFuzzyCandidates > 0 && banded == maxFuzzyCandidates {
+					break band
+				}
+				if !strong[i] && !skip(i) {
+					candidates = append(candidates, i)
+					banded++
+				}
+			}
+			if gap == 0 {
+				break
+			}
+		}
+	}
+
+	var best *fuzzyEntry
+	var bestScore float64
+	for _, i := range candidates {
+		candidate := &index.entries[i]
+		score, ok := matchScore(query, candidate.name)
+		if !ok {
+			continue
+		}
+		if best == nil || score > bestScore || (score == bestScore && candidate.name < best.name) {
+			best, bestScore = candidate, score
+		}
+	}
+
+	if best == nil {
+		return resolvedOrigin{}, false
+	}
+	origin := best.origin
+	origin.Fuzzy = true
+	return origin, true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// editDistance counts the edits between two names. Plain Levenshtein is the
+// default; -edit-distance=damerau also counts swapping two adjacent
+// letters, the most common typo, as a single edit.
+var editDistance = levenshtein.ComputeDistance
+
+var editDistances = map[string]func(a, b string) int{
+	"levenshtein": levenshtein.ComputeDistance,
+	"damerau":     damerauDistance,
+}
+
+// damerauDistance is the optimal string alignment distance: Levenshtein
+// plus transposition of adjacent runes, with no substring edited twice.
+func damerauDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	curr := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		curr[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(t)]
+}
+
+// matchScore rates how well query matches candidate, higher being better.
+// Candidates that are neither within maxFuzzyDistance edits, prefixed by the
+// query, nor share a word with it are rejected.
+func matchScore(query, candidate string) (float64, bool) {
+	distance := editDistance(query, candidate)
+	prefix := len(query) >= minPrefixLength && strings.HasPrefix(candidate, query)
+	overlap := tokenOverlap(query, candidate)
+	if distance > maxFuzzyDistance && !prefix && overlap == 0 {
+		return 0, false
+	}
+
+	longest := max(len(query), len(candidate))
+	score := matchWeights.Edit * (1 - float64(distance)/float64(longest))
+	if prefix {
+		score += matchWeights.Prefix * float64(len(query)) / float64(len(candidate))
+	}
+	score += matchWeights.Token * overlap
+	return score, true
+}
+
+// tokenOverlap returns the fraction of the candidate's words found in query.
+func tokenOverlap(query, candidate string) float64 {
+	candidateTokens := tokenize(candidate)
+	if len(candidateTokens) == 0 {
+		return 0
+	}
+	queryTokens := make(map[string]bool)
+	for _, token := range tokenize(query) {
+		queryTokens[token] = true
+	}
+	shared := 0
+	for _, token := range candidateTokens {
+		if queryTokens[token] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(candidateTokens))
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+var nameMatchModes = []string{"exact", "prefix", "contains", "fuzzy"}
+
+// matchName reports whether a property name matches the normalized query
+// under mode. Fuzzy matches also return a score for ranking, taken from the
+// best of the whole name and each of its words so that a misspelt word
+// still matches.
+func matchName(mode, query, name string) (float64, bool) {
+	name = NormalizeQuery(name)
+	switch mode {
+	case "exact":
+		return 0, name == query
+	case "prefix":
+		return 0, strings.HasPrefix(name, query)
+	case "contains":
+		return 0, strings.Contains(name, query)
+	}
+
+	best, matched := matchScore(query, name)
+	for _, word := range tokenize(name) {
+		if score, ok := matchScore(query, word); ok && (!matched || score > best) {
+			best, matched = score, true
+		}
+	}
+	return best, matched
+}
+
+// matchPropertyName is matchName over a property's name and its aliases,
+// returning the best score of those that match.
+func matchPropertyName(mode, query string, prop Property) (float64, bool) {
+	best, matched := matchName(mode, query, prop.Name)
+	for _, alias := range prop.Aliases {
+		if score, ok := matchName(mode, query, alias); ok && (!matched || score > best) {
+			best, matched = score, true
+		}
+	}
+	return best, matched
+}
+
+// findPropertyByName looks up a property whose name equals query, ignoring
+// case, so a search can be centered on a specific stay. Names take
+// precedence over aliases, so an alias cannot hide another property.
+func findPropertyByName(query string) (Property, bool) {
+	query = NormalizeQuery(query)
+	props := currentProperties()
+	for _, prop := range props {
+		if NormalizeQuery(prop.Name) == query {
+			return prop, true
+		}
+	}
+	for _, prop := range props {
+		for _, alias := range prop.Aliases {
+			if NormalizeQuery(alias) == query {
+				return prop, true
+			}
+		}
+	}
+	return Property{}, false
+}
+
+// suggestCities returns up to n city names closest to query by edit distance,
+// for queries that did not resolve to any location.
+func suggestCities(query string, n int) []string {
+	query = NormalizeQuery(query)
+	type candidate struct {
+		city     string
+		distance int
+	}
+	candidates := make([]candidate, 0, len(cityCenters))
+	for city := range cityCenters {
+		candidates = append(candidates, candidate{city, editDistance(query, city)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].city < candidates[j].city
+	})
+
+	suggestions := make([]string, 0, n)
+	for _, c := range candidates {
+		if len(suggestions) == n {
+			break
+		}
+		suggestions = append(suggestions, c.city)
+	}
+	return suggestions
+}
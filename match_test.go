@@ -0,0 +1,457 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// withCities swaps cityCenters for the duration of a test.
+func withCities(t testing.TB, cities map[string]Coordinates) {
+	t.Helper()
+	previous := cityCenters
+	cityCenters = cities
+	resetFuzzyIndex()
+	t.Cleanup(func() {
+		cityCenters = previous
+		resetFuzzyIndex()
+	})
+}
+
+func TestFindBestMatchPrefersPrefixes(t *testing.T) {
+	withCities(t, map[string]Coordinates{
+		"jaipur":    {26.9124, 75.7873},
+		"jaisalmer": {26.9157, 70.9083},
+		"udaipur":   {24.5854, 73.7125},
+	})
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"jai", "jaipur"},
+		{"jaisal", "jaisalmer"},
+		{"JAIS", "jaisalmer"},
+		{"udaipr", "udaipur"},
+	}
+	for _, tt := range tests {
+		origin, ok := findBestMatch(tt.query)
+		if !ok || origin.Label != tt.want {
+			t.Errorf("findBestMatch(%q) = %q, %v; want %q", tt.query, origin.Label, ok, tt.want)
+		}
+		if ok && !origin.Fuzzy {
+			t.Errorf("findBestMatch(%q) is not marked fuzzy", tt.query)
+		}
+	}
+}
+
+// withFuzzyPool swaps fuzzyPool for the duration of a test.
+func withFuzzyPool(t testing.TB, pool fuzzyPoolSet) {
+	t.Helper()
+	previous := fuzzyPool
+	fuzzyPool = pool
+	resetFuzzyIndex()
+	t.Cleanup(func() {
+		fuzzyPool = previous
+		resetFuzzyIndex()
+	})
+}
+
+func TestFindBestMatchPropertyNames(t *testing.T) {
+	withCities(t, map[string]Coordinates{"udaipur": {24.5854, 73.7125}})
+	withCatalog(t, []Property{{Name: "Lake Palace Retreat", Latitude: 24.5763, Longitude: 73.6835}})
+	const query = "lake palace retreet"
+
+	withFuzzyPool(t, fuzzyPoolSet{"cities": true, "properties": true})
+	origin, ok := findBestMatch(query)
+	if !ok || origin.Label != "Lake Palace Retreat" || !origin.FromProperty || !origin.Fuzzy {
+		t.Errorf("with properties in the pool, findBestMatch(%q) = %+v, %v; want a fuzzy match of Lake Palace Retreat", query, origin, ok)
+	}
+	if origin.Lat != 24.5763 || origin.Lon != 73.6835 {
+		t.Errorf("origin at (%v, %v), want the property's coordinates", origin.Lat, origin.Lon)
+	}
+
+	withFuzzyPool(t, fuzzyPoolSet{"cities": true})
+	if origin, ok := findBestMatch(query); ok {
+		t.Errorf("without properties in the pool, findBestMatch(%q) = %+v; want no match", query, origin)
+	}
+}
+
+func TestMatchScorePrefixAgainstEditDistance(t *testing.T) {
+	// "jaisalmer" only shares a prefix with "jais"; "jails" is one edit
+	// away. The prefix weight decides which wins.
+	tests := []struct {
+		name   string
+		prefix float64
+		want   string
+	}{
+		{"default weights", 1, "jaisalmer"},
+		{"prefix weighted up", 2, "jaisalmer"},
+		{"prefix ignored", 0, "jails"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := matchWeights
+			matchWeights.Prefix = tt.prefix
+			t.Cleanup(func() { matchWeights = previous })
+
+			prefixScore, prefixOK := matchScore("jais", "jaisalmer")
+			editScore, editOK := matchScore("jais", "jails")
+			if !prefixOK || !editOK {
+				t.Fatalf("matchScore rejected a candidate: prefix %v, edit %v", prefixOK, editOK)
+			}
+			got := "jails"
+			if prefixScore > editScore {
+				got = "jaisalmer"
+			}
+			if got != tt.want {
+				t.Errorf("preferred %q (prefix %.3f, edit %.3f), want %q", got, prefixScore, editScore, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchScoreRejectsUnrelatedNames(t *testing.T) {
+	tests := []struct {
+		query, candidate string
+		want             bool
+	}{
+		{"jaipur", "jaipur", true},
+		{"jaipr", "jaipur", true},
+		{"moustache", "moustache delhi", true},
+		{"ja", "jaipur", false},
+		{"goa", "jaipur", false},
+	}
+	for _, tt := range tests {
+		if _, ok := matchScore(tt.query, tt.candidate); ok != tt.want {
+			t.Errorf("matchScore(%q, %q) accepted = %v, want %v", tt.query, tt.candidate, ok, tt.want)
+		}
+	}
+}
+
+func TestDamerauDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"jaipur", "jaipur", 0},
+		{"jiapur", "jaipur", 1},
+		{"udiapur", "udaipur", 1},
+		{"jaipur", "jaipru", 1},
+		{"jaipur", "jaipr", 1},
+		{"ca", "abc", 3},
+		{"", "goa", 3},
+		{"ūdaipur", "duaipur", 2},
+	}
+	for _, tt := range tests {
+		if got := damerauDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("damerauDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFindBestMatchTranspositions(t *testing.T) {
+	withCities(t, map[string]Coordinates{
+		"jaipur":  {26.9124, 75.7873},
+		"udaipur": {24.5854, 73.7125},
+	})
+	tests := []struct {
+		distance string
+		query    string
+		wantOK   bool
+	}{
+		{"levenshtein", "jiapur", false},
+		{"damerau", "jiapur", true},
+		{"levenshtein", "jaipru", false},
+		{"damerau", "jaipru", true},
+		{"damerau", "japiru", false},
+	}
+	for _, tt := range tests {
+		previous := editDistance
+		editDistance = editDistances[tt.distance]
+		origin, ok := findBestMatch(tt.query)
+		editDistance = previous
+		if ok != tt.wantOK || (ok && origin.Label != "jaipur") {
+			t.Errorf("%s: findBestMatch(%q) = %q, %v; want jaipur: %v", tt.distance, tt.query, origin.Label, ok, tt.wantOK)
+		}
+	}
+}
+
+func TestMatchName(t *testing.T) {
+	const name = "Moustache Udaipur Luxuria"
+	tests := []struct {
+		mode, query string
+		want        bool
+	}{
+		{"exact", "moustache udaipur luxuria", true},
+		{"exact", "moustache udaipur", false},
+		{"prefix", "moustache udai", true},
+		{"prefix", "udaipur", false},
+		{"contains", "udaipur lux", true},
+		{"contains", "luxuria udaipur", false},
+		{"fuzzy", "luxurai", false},
+		{"fuzzy", "luxuri", true},
+		{"fuzzy", "udaipr", true},
+		{"fuzzy", "verandah", false},
+	}
+	for _, tt := range tests {
+		if _, got := matchName(tt.mode, tt.query, name); got != tt.want {
+			t.Errorf("matchName(%q, %q, %q) = %v, want %v", tt.mode, tt.query, name, got, tt.want)
+		}
+	}
+}
+
+func TestSearchNameMatchModes(t *testing.T) {
+	withCatalog(t, []Property{
+		{Name: "Lake Stay", Latitude: 24.58, Longitude: 73.68},
+		{Name: "Lakeside Stay", Latitude: 24.60, Longitude: 73.70},
+		{Name: "Hill Stay", Latitude: 24.59, Longitude: 73.71, Aliases: []string{"The Lake View"}},
+	})
+	tests := []struct {
+		rawQuery string
+		want     []string
+	}{
+		{"name=lake+stay&name_match=exact", []string{"Lake Stay"}},
+		{"name=lake&name_match=prefix", []string{"Lake Stay", "Lakeside Stay"}},
+		{"name=lake", []string{"Hill Stay", "Lake Stay", "Lakeside Stay"}},
+		{"name=lakesid&name_match=fuzzy", []string{"Lakeside Stay"}},
+	}
+	for _, tt := range tests {
+		withFreshCache(t)
+		rec, response := getSearch(t, "q=udaipur&"+tt.rawQuery)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.rawQuery, rec.Code, rec.Body.String())
+		}
+		got := resultNames(response)
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.rawQuery, got, tt.want)
+		}
+	}
+
+	if rec, _ := getSearch(t, "q=udaipur&name=lake&name_match=regex"); rec.Code != http.StatusBadRequest {
+		t.Errorf("name_match=regex: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDefaultNormalizeQuery(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"Udaipur", "udaipur"},
+		{"  UDAIPUR  ", "udaipur"},
+		{"New   Delhi", "new delhi"},
+		{"\tcity of\nlakes ", "city of lakes"},
+		{"", ""},
+		{"   ", ""},
+	}
+	for _, tt := range tests {
+		if got := defaultNormalizeQuery(tt.query); got != tt.want {
+			t.Errorf("defaultNormalizeQuery(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeQueryHook(t *testing.T) {
+	previous := NormalizeQuery
+	NormalizeQuery = func(query string) string {
+		if query = defaultNormalizeQuery(query); query == "lake town" {
+			return "udaipur"
+		}
+		return query
+	}
+	t.Cleanup(func() { NormalizeQuery = previous })
+
+	origin, ok := resolveExact("Lake Town")
+	if !ok || origin.City != "udaipur" {
+		t.Errorf("resolveExact(%q) = %q, %v; want udaipur", "Lake Town", origin.City, ok)
+	}
+	if a, b := searchCacheKey(NormalizeQuery("Lake Town"), searchOptions{}), searchCacheKey(NormalizeQuery("udaipur"), searchOptions{}); a != b {
+		t.Errorf("cache keys %q and %q differ for queries the hook treats as the same", a, b)
+	}
+}
+
+func TestMatchPropertyNameAliases(t *testing.T) {
+	prop := Property{Name: "Moustache Goa Luxuria", Aliases: []string{"Moustache Goa", "Luxuria Anjuna"}}
+	tests := []struct {
+		mode, query string
+		want        bool
+	}{
+		{"exact", "moustache goa luxuria", true},
+		{"exact", "moustache goa", true},
+		{"exact", "luxuria anjuna", true},
+		{"exact", "goa", false},
+		{"prefix", "luxuria an", true},
+		{"fuzzy", "anjna", true},
+	}
+	for _, tt := range tests {
+		if _, got := matchPropertyName(tt.mode, tt.query, prop); got != tt.want {
+			t.Errorf("matchPropertyName(%q, %q) = %v, want %v", tt.mode, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearchByAlias(t *testing.T) {
+	withFreshCache(t)
+	withCatalog(t, []Property{
+		{Name: "Moustache Lake Palace", Latitude: 24.58, Longitude: 73.68, Aliases: []string{"Lake Palace"}},
+		{Name: "Moustache Hill Fort", Latitude: 24.60, Longitude: 73.70},
+	})
+	_, response := getSearch(t, "q=udaipur&name=lake+palace&name_match=exact")
+	if got := resultNames(response); !slices.Equal(got, []string{"Moustache Lake Palace"}) {
+		t.Errorf("got %v, want the canonical name [Moustache Lake Palace]", got)
+	}
+}
+
+func TestFoldAccents(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"údaipur", "udaipur"},
+		{"jaïpur", "jaipur"},
+		{"u\u0301daipur", "udaipur"},
+		{"ñøłđ", "nold"},
+		{"उदयपुर", "उदयपुर"},
+	}
+	for _, tt := range tests {
+		if got := foldAccents(tt.s); got != tt.want {
+			t.Errorf("foldAccents(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestExactMatchIgnoresCaseAndAccents(t *testing.T) {
+	for _, query := range []string{"Udaipur", "UDAIPUR", "udaipur", "Údaipur", "ÜDAÏPUR", "u\u0301daipur"} {
+		origin, ok := resolveExact(query)
+		if !ok || origin.City != "udaipur" || origin.Fuzzy {
+			t.Errorf("resolveExact(%q) = %q, %v, fuzzy %v; want an exact udaipur", query, origin.City, ok, origin.Fuzzy)
+		}
+		if got, want := searchCacheKey(NormalizeQuery(query), searchOptions{}), searchCacheKey("udaipur", searchOptions{}); got != want {
+			t.Errorf("%q has cache key %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestSearchAccentedQueriesShareCache(t *testing.T) {
+	withFreshCache(t)
+	previous := exactOnlyMatching
+	exactOnlyMatching = true
+	t.Cleanup(func() { exactOnlyMatching = previous })
+
+	for _, query := range []string{"Údaipur", "UDAIPUR", "udaipur"} {
+		rec, response := getSearch(t, "q="+url.QueryEscape(query))
+		if rec.Code != http.StatusOK || response.Message == "Location not recognized" {
+			t.Errorf("%q: status %d, message %q; want an exact match", query, rec.Code, response.Message)
+		}
+	}
+	if entries := len(searchCache.(*memoryCache).entries); entries != 1 {
+		t.Errorf("three spellings of udaipur made %d cache entries, want 1", entries)
+	}
+}
+
+// syntheticCities returns n made-up city names with coordinates, the same
+// ones on every call.
+func syntheticCities(n int) map[string]Coordinates {
+	random := rand.New(rand.NewPCG(3, 4))
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	cities := make(map[string]Coordinates, n)
+	for len(cities) < n {
+		name := make([]byte, 4+random.IntN(9))
+		for i := range name {
+			name[i] = letters[random.IntN(len(letters))]
+		}
+		cities[string(name)] = Coordinates{Lat: 8 + random.Float64()*27, Lon: 68 + random.Float64()*29}
+	}
+	return cities
+}
+
+func TestFuzzyPrefilterKeepsBuiltInResults(t *testing.T) {
+	// Scoring every city directly is what findBestMatch did before its
+	// pre-filter, and must still pick the same one.
+	bruteForce := func(query string) (string, bool) {
+		var best string
+		var bestScore float64
+		for city := range cityCenters {
+			score, ok := matchScore(query, city)
+			if ok && (best == "" || score > bestScore || (score == bestScore && city < best)) {
+				best, bestScore = city, score
+			}
+		}
+		return best, best != ""
+	}
+	check := func(queries []string) {
+		t.Helper()
+		for _, query := range queries {
+			want, wantOK := bruteForce(query)
+			got, ok := findBestMatch(query)
+			if got.Label != want || ok != wantOK {
+				t.Errorf("findBestMatch(%q) = %q, %v; scoring every city gives %q, %v", query, got.Label, ok, want, wantOK)
+			}
+		}
+	}
+	check([]string{"jai", "jaipr", "udaipr", "jaisal", "jaismer", "delhi", "dlhi", "goa", "xyz"})
+
+	withCities(t, syntheticCities(5000))
+	var queries []string
+	for city := range cityCenters {
+		if len(queries) >= 60 {
+			break
+		}
+		// A prefix, a typo and a word of the name.
+		queries = append(queries, city[:3], city[1:], city[:2]+" "+city)
+	}
+	check(queries)
+}
+
+func TestFuzzyCapKeepsPrefixAndWordMatches(t *testing.T) {
+	cities := syntheticCities(2000)
+	cities["jaipur"] = Coordinates{Lat: 26.9124, Lon: 75.7873}
+	withCities(t, cities)
+	previous := maxFuzzyCandidates
+	maxFuzzyCandidates = 5
+	t.Cleanup(func() { maxFuzzyCandidates = previous })
+
+	// Hundreds of names are as long as each query, far more than the cap,
+	// but only the prefix or the shared word makes jaipur a match.
+	for _, query := range []string{"jai", "old jaipur"} {
+		origin, ok := findBestMatch(query)
+		if !ok || origin.Label != "jaipur" {
+			t.Errorf("findBestMatch(%q) with a cap of %d = %q, %v; want jaipur", query, maxFuzzyCandidates, origin.Label, ok)
+		}
+	}
+}
+
+// BenchmarkFindBestMatch measures fuzzy matching against 50,000 cities:
+// scoring every name, building the fuzzy index, and looking names up in it
+// with and without a candidate cap.
+func BenchmarkFindBestMatch(b *testing.B) {
+	withCities(b, syntheticCities(50000))
+	previous := maxFuzzyCandidates
+	b.Cleanup(func() { maxFuzzyCandidates = previous })
+	const query = "jaisalmre"
+
+	b.Run("unfiltered", func(b *testing.B) {
+		for b.Loop() {
+			for city := range cityCenters {
+				matchScore(query, city)
+			}
+		}
+	})
+	b.Run("index", func(b *testing.B) {
+		for b.Loop() {
+			resetFuzzyIndex()
+			currentFuzzyIndex()
+		}
+	})
+	currentFuzzyIndex()
+	for _, limit := range []int{0, 20} {
+		b.Run(fmt.Sprintf("prefiltered/max-candidates=%d", limit), func(b *testing.B) {
+			maxFuzzyCandidates = limit
+			for b.Loop() {
+				findBestMatch(query)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultLanguage = "en"
+
+// Message keys used in search responses. Templates are fmt formats that
+// receive the arguments shown as strings already formatted for the
+// language; translations can reorder them with explicit indexes such as
+// %[2]s.
+const (
+	msgFound        = "found"        // count, radius, unit
+	msgNoneFound    = "none_found"   // radius, unit
+	msgUnrecognized = "unrecognized" // no arguments
+	msgDistanceKm   = "distance_km"  // distance
+	msgDistanceMi   = "distance_mi"  // distance
+	msgDistanceM    = "distance_m"   // distance
+	msgUnitKm       = "unit_km"      // no arguments
+	msgUnitMi       = "unit_mi"      // no arguments
+	msgUnitM        = "unit_m"       // no arguments
+)
+
+// unitMessages names each distance unit for the messages that quote the
+// radius.
+var unitMessages = map[string]string{"km": msgUnitKm, "mi": msgUnitMi, "m": msgUnitM}
+
+// messageCatalog maps a language tag to its message templates. Languages
+// may be partial; missing keys fall back to defaultLanguage.
+var messageCatalog = map[string]map[string]string{
+	"en": {
+		msgFound:        "Found %[1]s properties within %[2]s%[3]s",
+		msgNoneFound:    "No properties found within %[1]s%[2]s",
+		msgUnrecognized: "Location not recognized",
+		msgDistanceKm:   "%[1]s km away",
+		msgDistanceMi:   "%[1]s mi away",
+		msgDistanceM:    "%[1]s m away",
+		msgUnitKm:       "km",
+		msgUnitMi:       "mi",
+		msgUnitM:        "m",
+	},
+	"hi": {
+		msgFound:        "%[2]s %[3]s के भीतर %[1]s प्रॉपर्टी मिलीं",
+		msgNoneFound:    "%[1]s %[2]s के भीतर कोई प्रॉपर्टी नहीं मिली",
+		msgUnrecognized: "स्थान पहचाना नहीं गया",
+		msgDistanceKm:   "%[1]s किमी दूर",
+		msgDistanceMi:   "%[1]s मील दूर",
+		msgDistanceM:    "%[1]s मीटर दूर",
+		msgUnitKm:       "किमी",
+		msgUnitMi:       "मील",
+		msgUnitM:        "मीटर",
+	},
+}
+
+// localize formats the message key in lang, formatting numeric arguments
+// with the language's numberFormat.
+func localize(lang, key string, args ...any) string {
+	template, exists := messageCatalog[lang][key]
+	if !exists {
+		template = messageCatalog[defaultLanguage][key]
+	}
+	format, exists := numberFormats[lang]
+	if !exists {
+		format = numberFormats[defaultLanguage]
+	}
+	formatted := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case int:
+			formatted[i] = format.format(float64(v))
+		case float64:
+			formatted[i] = format.format(v)
+		default:
+			formatted[i] = arg
+		}
+	}
+	return fmt.Sprintf(template, formatted...)
+}
+
+// numberFormat describes how a language writes numbers. Indian grouping
+// separates the last three integer digits and then every two, as in
+// 12,34,567.
+type numberFormat struct {
+	Group          string
+	Decimal        string
+	IndianGrouping bool
+}
+
+// numberFormats covers the catalog languages; others use defaultLanguage's.
+var numberFormats = map[string]numberFormat{
+	"en": {Group: ",", Decimal: "."},
+	"hi": {Group: ",", Decimal: ".", IndianGrouping: true},
+}
+
+func (f numberFormat) format(v float64) string {
+	raw := strconv.FormatFloat(math.Abs(v), 'f', -1, 64)
+	integer, fraction, _ := strings.Cut(raw, ".")
+
+	var groups []string
+	size := 3
+	for len(integer) > size {
+		groups = append([]string{integer[len(integer)-size:]}, groups...)
+		integer = integer[:len(integer)-size]
+		if f.IndianGrouping {
+			size = 2
+		}
+	}
+	groups = append([]string{integer}, groups...)
+
+	formatted := strings.Join(groups, f.Group)
+	if fraction != "" {
+		formatted += f.Decimal + fraction
+	}
+	if v < 0 {
+		formatted = "-" + formatted
+	}
+	return formatted
+}
+
+// loadMessages reads a JSON object of language to key to template and
+// merges it over the built-in catalog, so a file can add a language or
+// reword individual messages.
+func loadMessages(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var loaded map[string]map[string]string
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for lang, messages := range loaded {
+		lang = strings.ToLower(lang)
+		if messageCatalog[lang] == nil {
+			messageCatalog[lang] = make(map[string]string)
+		}
+		for key, template := range messages {
+			if _, known := messageCatalog[defaultLanguage][key]; !known {
+				log.Printf("Ignoring unknown message %q for language %q", key, lang)
+				continue
+			}
+			messageCatalog[lang][key] = template
+		}
+	}
+	return nil
+}
+
+// negotiateLanguage picks the catalog language the Accept-Language header
+// prefers most, matching on the primary subtag so "hi-IN" selects "hi".
+func negotiateLanguage(header string) string {
+	type preference struct {
+		lang string
+		q    float64
+	}
+	var preferences []preference
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, exists := messageCatalog[primary]; exists && q > 0 {
+			preferences = append(preferences, preference{primary, q})
+		}
+	}
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].q > preferences[j].q
+	})
+	if len(preferences) == 0 {
+		return defaultLanguage
+	}
+	return preferences[0].lang
+}
+
+// localizedRadius returns radiusKm in the unit the client asked for,
+// kilometers by default, with that unit's name in lang.
+func localizedRadius(lang string, radiusKm float64, unit string, precision int) (float64, string) {
+	if unit == "" {
+		return radiusKm, localize(lang, msgUnitKm)
+	}
+	return convertDistance(radiusKm, unit, precision), localize(lang, unitMessages[unit])
+}
+
+// labelMetersBelowKm is the distance under which distance labels switch
+// from kilometers to meters.
+var labelMetersBelowKm = 1.0
+
+// distanceLabel describes km for people, such as "12.3 km away" or
+// "800 m away", in lang. Miles are used when the client asked for them.
+func distanceLabel(lang string, km float64, unit string) string {
+	switch {
+	case unit == "mi":
+		return localize(lang, msgDistanceMi, convertDistance(km, "mi", 1))
+	case km < labelMetersBelowKm:
+		return localize(lang, msgDistanceM, convertDistance(km, "m", 0))
+	default:
+		return localize(lang, msgDistanceKm, convertDistance(km, "km", 1))
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheEventCapacity bounds the memory of the rolling hit ratio. When more
+// lookups than this happen within the window, the ratio covers only the
+// most recent ones.
+const cacheEventCapacity = 4096
+
+// hitRatioWindow is how far back the rolling cache hit ratio looks.
+var hitRatioWindow = time.Minute
+
+type cacheEvent struct {
+	at  time.Time
+	hit bool
+}
+
+// cacheMetrics counts search cache lookups, both since startup and over
+// hitRatioWindow, the latter from a ring buffer of recent lookups.
+type cacheMetrics struct {
+	mu     sync.Mutex
+	hits   int64
+	misses int64
+	events [cacheEventCapacity]cacheEvent
+	next   int
+	filled bool
+}
+
+var searchCacheMetrics cacheMetrics
+
+func (m *cacheMetrics) record(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.hits++
+	} else {
+		m.misses++
+	}
+	m.events[m.next] = cacheEvent{at: time.Now(), hit: hit}
+	m.next = (m.next + 1) % cacheEventCapacity
+	if m.next == 0 {
+		m.filled = true
+	}
+}
+
+// cacheMetricsSnapshot is a consistent view of cacheMetrics. WindowRatio is
+// NaN when there were no lookups in the window.
+type cacheMetricsSnapshot struct {
+	Hits        int64
+	Misses      int64
+	WindowRatio float64
+}
+
+func (m *cacheMetrics) snapshot() cacheMetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := m.next
+	if m.filled {
+		count = cacheEventCapacity
+	}
+	cutoff := time.Now().Add(-hitRatioWindow)
+	var hits, lookups int
+	// Walk backwards from the newest event so the scan stops at the window.
+	for i := 1; i <= count; i++ {
+		event := m.events[(m.next-i+cacheEventCapacity)%cacheEventCapacity]
+		if event.at.Before(cutoff) {
+			break
+		}
+		lookups++
+		if event.hit {
+			hits++
+		}
+	}
+	ratio := math.NaN()
+	if lookups > 0 {
+		ratio = float64(hits) / float64(lookups)
+	}
+	return cacheMetricsSnapshot{Hits: m.hits, Misses: m.misses, WindowRatio: ratio}
+}
+
+// maxCountedPlaces bounds how many distinct places searchCounts tracks;
+// searches for places beyond it are counted under otherPlaces.
+var maxCountedPlaces = 1000
+
+const otherPlaces = "(other)"
+
+// searchCounter counts searches served, in total and per place. Counters
+// are atomics so that recording a search only takes the map lock the first
+// time a place is seen.
+type searchCounter struct {
+	total   atomic.Int64
+	mu      sync.RWMutex
+	byPlace map[string]*atomic.Int64
+}
+
+var searchCounts = searchCounter{byPlace: make(map[string]*atomic.Int64)}
+
+// record counts a search, for place too unless it is empty.
+func (c *searchCounter) record(place string) {
+	c.total.Add(1)
+	if place == "" {
+		return
+	}
+	c.mu.RLock()
+	counter := c.byPlace[place]
+	c.mu.RUnlock()
+	if counter == nil {
+		c.mu.Lock()
+		if counter = c.byPlace[place]; counter == nil {
+			if len(c.byPlace) >= maxCountedPlaces {
+				place = otherPlaces
+			}
+			if counter = c.byPlace[place]; counter == nil {
+				counter = new(atomic.Int64)
+				c.byPlace[place] = counter
+			}
+		}
+		c.mu.Unlock()
+	}
+	counter.Add(1)
+}
+
+func (c *searchCounter) snapshot() (int64, map[string]int64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	byPlace := make(map[string]int64, len(c.byPlace))
+	for place, counter := range c.byPlace {
+		byPlace[place] = counter.Load()
+	}
+	return c.total.Load(), byPlace
+}
+
+// searchedPlace names what a search was for in searchCounts: the city for
+// city and alias queries, otherwise the normalized query, so that places
+// people look for but cityCenters lacks show up too. Pin, airport and near
+// searches are only counted in the total.
+func searchedPlace(query string, opts searchOptions) string {
+	if opts.Pin != "" || opts.Airport != "" || opts.Near != "" {
+		return ""
+	}
+	query = NormalizeQuery(query)
+	if city, exists := cityAliases[query]; exists {
+		return city
+	}
+	return query
+}
+
+// metricsHandler serves the cache metrics in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := searchCacheMetrics.snapshot()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP search_cache_hits_total Search cache lookups that found an entry.\n")
+	fmt.Fprintf(w, "# TYPE search_cache_hits_total counter\n")
+	fmt.Fprintf(w, "search_cache_hits_total %d\n", snapshot.Hits)
+	fmt.Fprintf(w, "# HELP search_cache_misses_total Search cache lookups that found no entry.\n")
+	fmt.Fprintf(w, "# TYPE search_cache_misses_total counter\n")
+	fmt.Fprintf(w, "search_cache_misses_total %d\n", snapshot.Misses)
+	fmt.Fprintf(w, "# HELP search_cache_hit_ratio Search cache hit ratio over the last %s.\n", hitRatioWindow)
+	fmt.Fprintf(w, "# TYPE search_cache_hit_ratio gauge\n")
+	fmt.Fprintf(w, "search_cache_hit_ratio %g\n", snapshot.WindowRatio)
+	fmt.Fprintf(w, "# HELP searches_total Searches served.\n")
+	fmt.Fprintf(w, "# TYPE searches_total counter\n")
+	fmt.Fprintf(w, "searches_total %d\n", searchCounts.total.Load())
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// limitConcurrency returns a wrapper that shares limit slots between every
+// handler it wraps, rejecting requests with 503 while all slots are in use.
+func limitConcurrency(limit int) func(http.HandlerFunc) http.HandlerFunc {
+	sem := make(chan struct{}, limit)
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				next(w, r)
+			default:
+				http.Error(w, "Server is busy, try again later", http.StatusServiceUnavailable)
+			}
+		}
+	}
+}
+
+// requireAPIKey rejects requests with 401 unless they carry one of keys in
+// the X-API-Key or Authorization header. A nil keys map disables the check.
+func requireAPIKey(keys map[string]bool) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if keys == nil {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := apiKeyFromRequest(r)
+			if key == "" {
+				http.Error(w, "API key required", http.StatusUnauthorized)
+				return
+			}
+			if !keys[key] {
+				log.Printf("Rejected invalid API key %s for %s", hashAPIKey(key), r.URL.Path)
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			log.Printf("API key %s: %s %s", hashAPIKey(key), r.Method, r.URL.Path)
+			next(w, r)
+		}
+	}
+}
+
+// loadAPIKeys collects valid keys from the comma-separated API_KEYS
+// environment variable and, if path is set, a file with one key per line.
+func loadAPIKeys(path string) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	for _, key := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys[key] = true
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if key := strings.TrimSpace(line); key != "" && !strings.HasPrefix(key, "#") {
+				keys[key] = true
+			}
+		}
+	}
+	return keys, nil
+}
+
+// dailyQuota rejects clients with 429 once they have made limit requests in
+// the current UTC day. Clients are identified by API key when they send one
+// and by IP otherwise. Counters live in searchCache so that every instance
+// sharing the cache enforces the same quota.
+func dailyQuota(limit int) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if limit <= 0 {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			now := time.Now().UTC()
+			midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+			key := fmt.Sprintf("quota:%s:%s", clientID(r), now.Format("2006-01-02"))
+			if searchCache.Incr(key, midnight.Sub(now)+time.Minute) > int64(limit) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(midnight.Sub(now).Seconds())+1))
+				http.Error(w, "Daily quota exceeded, resets at midnight UTC", http.StatusTooManyRequests)
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// clientID identifies the caller for quotas: a hash of its API key, or its
+// IP address when it sent no key.
+func clientID(r *http.Request) string {
+	if key := apiKeyFromRequest(r); key != "" {
+		return "key:" + hashAPIKey(key)
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP returns the address the request came from, without its port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+		return strings.TrimSpace(auth)
+	}
+	return ""
+}
+
+// hashAPIKey returns a short, stable fingerprint of key that is safe to log
+// or store.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// gzipResponses compresses responses for clients that accept gzip once the
+// body reaches minSize bytes. Smaller bodies are sent as-is since the gzip
+// framing would outweigh the savings.
+func gzipResponses(minSize int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: minSize}
+		// Not deferred: after a panic, a body still buffered is dropped so
+		// that recoverPanics can send its error instead.
+		next.ServeHTTP(gw, r)
+		gw.finish()
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(encoding), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it knows whether
+// the body is large enough to be worth compressing.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buf     []byte
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends whatever has been buffered so far, compressing it if the body
+// has not been committed to plain output yet.
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		if err := w.startGzip(); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) startGzip() error {
+	w.decided = true
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		w.writeHeader()
+		_, err := w.ResponseWriter.Write(w.buf)
+		return err
+	}
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.writeHeader()
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf)
+	return err
+}
+
+func (w *gzipResponseWriter) writeHeader() {
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *gzipResponseWriter) finish() {
+	if w.decided {
+		if w.gz != nil {
+			w.gz.Close()
+		}
+		return
+	}
+	w.decided = true
+	w.writeHeader()
+	if len(w.buf) > 0 {
+		w.ResponseWriter.Write(w.buf)
+	}
+}
+
+// responseTimer stamps X-Response-Time with the time since start as the
+// header is written, so the value covers all handler work up to the first
+// byte of the response.
+type responseTimer struct {
+	http.ResponseWriter
+	start   time.Time
+	stamped bool
+}
+
+func newResponseTimer(w http.ResponseWriter) *responseTimer {
+	return &responseTimer{ResponseWriter: w, start: time.Now()}
+}
+
+func (w *responseTimer) stamp() {
+	if !w.stamped {
+		w.stamped = true
+		elapsed := time.Since(w.start)
+		w.Header().Set("X-Response-Time", fmt.Sprintf("%.3fms", float64(elapsed.Microseconds())/1000))
+	}
+}
+
+func (w *responseTimer) WriteHeader(status int) {
+	w.stamp()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseTimer) Write(p []byte) (int, error) {
+	w.stamp()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *responseTimer) Flush() {
+	w.stamp()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// trimTrailingSlash serves non-GET requests for "/path/" as "/path". The
+// router's StrictSlash redirects GETs, but clients re-send a redirected
+// POST as a GET without its body.
+func trimTrailingSlash(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && len(r.URL.Path) > 1 && strings.HasSuffix(r.URL.Path, "/") {
+			r.URL.Path = "/" + strings.Trim(r.URL.Path, "/")
+			r.URL.RawPath = ""
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverPanics turns a panicking handler into a JSON 500 instead of a
+// dropped connection, logging the panic and its stack. It wraps every other
+// middleware so that it sees the response they actually sent. Once the
+// header is out a clean error can no longer be sent, so the response is
+// aborted with http.ErrAbortHandler instead, which is also re-raised as is
+// since it is how handlers deliberately abort a response.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tracker := &headerTracker{ResponseWriter: w}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Printf("Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			if tracker.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			w.Header().Del("Content-Encoding")
+			w.Header().Del("ETag")
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":"Internal server error"}` + "\n"))
+		}()
+		next.ServeHTTP(tracker, r)
+	})
+}
+
+// headerTracker remembers whether the response header has been sent.
+type headerTracker struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *headerTracker) WriteHeader(status int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerTracker) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *headerTracker) Flush() {
+	w.wroteHeader = true
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestGzipResponsesThreshold(t *testing.T) {
+	const minSize = 1024
+	tests := []struct {
+		name           string
+		size           int
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"below threshold", minSize - 1, "gzip", false},
+		{"at threshold", minSize, "gzip", true},
+		{"above threshold", 4 * minSize, "gzip, deflate", true},
+		{"gzip refused", 4 * minSize, "gzip;q=0", false},
+		{"no gzip accepted", 4 * minSize, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := bytes.Repeat([]byte("a"), tt.size)
+			handler := gzipResponses(minSize, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(body)
+			}))
+			req := httptest.NewRequest("GET", "/search", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			gzipped := rec.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("Content-Encoding gzip = %v, want %v", gzipped, tt.wantGzip)
+			}
+			got := rec.Body.Bytes()
+			if gzipped {
+				reader, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err = io.ReadAll(reader); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !bytes.Equal(got, body) {
+				t.Errorf("body is %d bytes, want %d", len(got), len(body))
+			}
+		})
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	handler := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			w.Header().Set("ETag", `"stale"`)
+			panic("index out of range")
+		}
+		io.WriteString(w, "fine")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/panic", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type %q, want application/json", contentType)
+	}
+	if etag := rec.Header().Get("ETag"); etag != "" {
+		t.Errorf("ETag %q survived the panic", etag)
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" {
+		t.Errorf("body %q is not a JSON error: %v", rec.Body.String(), err)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/fine", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "fine" {
+		t.Errorf("non-panicking request got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRecoverPanicsRepanicsAbort(t *testing.T) {
+	handler := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", err)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestRecoverPanicsAfterHeaderAborts(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	handler := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"properties": [`)
+		panic("index out of range")
+	}))
+	rec := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want http.ErrAbortHandler", err)
+			}
+		}()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	}()
+	if body := rec.Body.String(); body != `{"properties": [` {
+		t.Errorf("body %q, want the partial response left alone", body)
+	}
+}
+
+func TestRecoverPanicsOutsideMiddleware(t *testing.T) {
+	log.SetOutput(io.Discard)
+	var accessLines bytes.Buffer
+	accessLogger.SetOutput(&accessLines)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		accessLogger.SetOutput(os.Stdout)
+	})
+
+	// As in main: a body smaller than the gzip threshold is still buffered
+	// when the handler panics, so a clean error can replace it.
+	handler := recoverPanics(accessLog("json", gzipResponses(1024, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "partial")
+		panic("index out of range")
+	}))))
+	req := httptest.NewRequest("GET", "/search", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("status %d with Content-Encoding %q, want an uncompressed 500", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" {
+		t.Errorf("body %q is not a JSON error: %v", rec.Body.String(), err)
+	}
+	var entry accessLogEntry
+	if err := json.Unmarshal(accessLines.Bytes(), &entry); err != nil || entry.Status != http.StatusInternalServerError {
+		t.Errorf("access log %q, want the request logged with status 500: %v", accessLines.String(), err)
+	}
+}
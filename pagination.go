@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// defaultPageSize is the page_size of a cursor feed that does not set one.
+const defaultPageSize = 20
+
+// pageCursor is the position of the last property on a page. Pages resume
+// after it by value rather than by offset, so properties added or removed
+// between pages neither repeat nor skip the others.
+type pageCursor struct {
+	Distance float64 `json:"d"`
+	Name     string  `json:"n"`
+}
+
+func encodeCursor(c pageCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string) (pageCursor, error) {
+	var c pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil || json.Unmarshal(data, &c) != nil || c.Name == "" {
+		return pageCursor{}, errors.New("Query parameter 'cursor' is not a cursor this server returned")
+	}
+	return c, nil
+}
+
+// after reports whether result comes after the cursor in distance, then
+// name, order.
+func (c pageCursor) after(result PropertyResponse) bool {
+	if result.Distance != c.Distance {
+		return result.Distance > c.Distance
+	}
+	return result.Name > c.Name
+}
+
+// paginate applies the window or cursor page the client asked for.
+func paginate(response SearchResponse, opts searchOptions) (SearchResponse, error) {
+	response, err := applyWindow(response, opts)
+	if err != nil {
+		return SearchResponse{}, err
+	}
+	return applyCursor(response, opts), nil
+}
+
+// applyCursor narrows a response to the PageSize properties, in distance
+// then name order, after opts.Cursor, setting NextCursor unless it is the
+// last page. The cursor was validated by parseSearchParams.
+func applyCursor(response SearchResponse, opts searchOptions) SearchResponse {
+	if opts.PageSize == 0 || response.Unrecognized {
+		return response
+	}
+	ordered := make([]PropertyResponse, len(response.Properties))
+	copy(ordered, response.Properties)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Distance != ordered[j].Distance {
+			return ordered[i].Distance < ordered[j].Distance
+		}
+		return ordered[i].Name < ordered[j].Name
+	})
+	if response.Total < len(ordered) {
+		response.Total = len(ordered)
+	}
+
+	start := 0
+	if opts.Cursor != "" {
+		cursor, _ := decodeCursor(opts.Cursor)
+		start = sort.Search(len(ordered), func(i int) bool { return cursor.after(ordered[i]) })
+	}
+	end := min(start+opts.PageSize, len(ordered))
+	response.Properties = ordered[start:end]
+	response.NextCursor = ""
+	if end < len(ordered) {
+		last := ordered[end-1]
+		response.NextCursor = encodeCursor(pageCursor{Distance: last.Distance, Name: last.Name})
+	}
+	return response
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// pageThrough follows next_cursor from the first page of rawQuery,
+// calling between before each later page, and returns every name seen.
+func pageThrough(t *testing.T, rawQuery string, between func(page int)) []string {
+	t.Helper()
+	var names []string
+	cursor := ""
+	for page := 0; ; page++ {
+		if page > 0 && between != nil {
+			between(page)
+		}
+		query := rawQuery
+		if cursor != "" {
+			query += "&cursor=" + url.QueryEscape(cursor)
+		}
+		rec, response := getSearch(t, query)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("page %d: status %d: %s", page, rec.Code, rec.Body.String())
+		}
+		names = append(names, resultNames(response)...)
+		if cursor = response.NextCursor; cursor == "" {
+			return names
+		}
+		if page > 10 {
+			t.Fatal("cursors never reached the last page")
+		}
+	}
+}
+
+// pagedCatalog has five properties, numbered in distance order from the
+// center of Udaipur.
+var pagedCatalog = []Property{
+	{Name: "Stay 3", Latitude: 24.6154, Longitude: 73.7125},
+	{Name: "Stay 1", Latitude: 24.5954, Longitude: 73.7125},
+	{Name: "Stay 5", Latitude: 24.6354, Longitude: 73.7125},
+	{Name: "Stay 2", Latitude: 24.6054, Longitude: 73.7125},
+	{Name: "Stay 4", Latitude: 24.6254, Longitude: 73.7125},
+}
+
+func TestCursorPagination(t *testing.T) {
+	withFreshCache(t)
+	withCatalog(t, pagedCatalog)
+
+	want := []string{"Stay 1", "Stay 2", "Stay 3", "Stay 4", "Stay 5"}
+	for _, pageSize := range []string{"1", "2", "5", "50"} {
+		if got := pageThrough(t, "q=udaipur&page_size="+pageSize, nil); !slices.Equal(got, want) {
+			t.Errorf("page_size=%s: got %v, want %v", pageSize, got, want)
+		}
+	}
+	if entries := len(searchCache.(*memoryCache).entries); entries != 1 {
+		t.Errorf("paging made %d cache entries, want every page to share 1", entries)
+	}
+}
+
+func TestCursorPaginationSurvivesCatalogChanges(t *testing.T) {
+	withFreshCache(t)
+	withCatalog(t, pagedCatalog)
+
+	// After the first page, Stay 2 is seen and Stay 4 not yet; dropping
+	// them and adding Stay 0 before the cursor must not shift the rest.
+	got := pageThrough(t, "q=udaipur&page_size=2", func(page int) {
+		if page != 1 {
+			return
+		}
+		changed := []Property{{Name: "Stay 0", Latitude: 24.5904, Longitude: 73.7125}}
+		for _, prop := range pagedCatalog {
+			if prop.Name != "Stay 2" && prop.Name != "Stay 4" {
+				changed = append(changed, prop)
+			}
+		}
+		setCatalog(changed, 0)
+	})
+	if want := []string{"Stay 1", "Stay 2", "Stay 3", "Stay 5"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v with no repeats or skips", got, want)
+	}
+}
+
+func TestCursorPaginationErrors(t *testing.T) {
+	withFreshCache(t)
+	for _, rawQuery := range []string{
+		"q=udaipur&cursor=not-a-cursor",
+		"q=udaipur&page_size=0",
+		"q=udaipur&page_size=2&limit=3",
+		"q=udaipur&page_size=2&window=1:3",
+		"q=udaipur&page_size=2&sort=name",
+	} {
+		if rec, _ := getSearch(t, rawQuery); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", rawQuery, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSearchCacheKeyIgnoresPaging(t *testing.T) {
+	base := searchOptions{RadiusKm: defaultRadiusKm}
+	paged := base
+	paged.PageSize, paged.Cursor = 2, encodeCursor(pageCursor{Distance: 1.5, Name: "Stay 2"})
+	windowed := base
+	windowed.WindowStart, windowed.WindowEnd = 3, 5
+	for _, opts := range []searchOptions{paged, windowed} {
+		if got, want := searchCacheKey("udaipur", opts), searchCacheKey("udaipur", base); got != want {
+			t.Errorf("cache key %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxBodyBytes limits POST bodies so oversized payloads are rejected before
+// they are read into memory.
+var maxBodyBytes int64 = 1 << 20
+
+// maxBatchSize bounds the number of searches in one /search/batch request.
+var maxBatchSize = 50
+
+// searchBody is a POST search: an object whose keys are the GET /search
+// query parameters, as described by search.schema.json. Lists such as
+// exclude and radii may be arrays.
+type searchBody map[string]any
+
+func (b searchBody) params() (url.Values, error) {
+	params := make(url.Values, len(b))
+	for key, value := range b {
+		switch v := value.(type) {
+		case string:
+			params.Set(key, v)
+		case float64:
+			params.Set(key, strconv.FormatFloat(v, 'g', -1, 64))
+		case bool:
+			params.Set(key, strconv.FormatBool(v))
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				switch item := item.(type) {
+				case string:
+					items[i] = item
+				case float64:
+					items[i] = strconv.FormatFloat(item, 'g', -1, 64)
+				default:
+					return nil, fmt.Errorf("field '%s' must be a list of strings or numbers", key)
+				}
+			}
+			params.Set(key, strings.Join(items, ","))
+		case nil:
+		default:
+			return nil, fmt.Errorf("field '%s' must be a string, number, boolean or list", key)
+		}
+	}
+	return params, nil
+}
+
+// decodeBody decodes the request body into v, writing the error response
+// and returning false if it is too large or malformed.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(v)
+	if err == nil && decoder.More() {
+		err = errTrailingData
+	}
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Request body must not exceed %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	// A failed Decode leaves InputOffset at the start of the value, so the
+	// offset of a body that ends early is everything read so far.
+	offset := decoder.InputOffset()
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		buffered, _ := io.Copy(io.Discard, decoder.Buffered())
+		offset += buffered
+	}
+	writeBodyErrors(w, []schemaError{describeDecodeError(err, offset)})
+	return false
+}
+
+// decodeBatch decodes a JSON array of search bodies like decodeBody. The
+// elements are decoded one by one so that one of the wrong type is reported
+// by its index, which encoding/json leaves out of UnmarshalTypeError.Field
+// for array elements in Go 1.24.
+func decodeBatch(w http.ResponseWriter, r *http.Request) ([]searchBody, bool) {
+	var elements []json.RawMessage
+	if !decodeBody(w, r, &elements) {
+		return nil, false
+	}
+	bodies := make([]searchBody, len(elements))
+	var errs []schemaError
+	for i, element := range elements {
+		if err := json.Unmarshal(element, &bodies[i]); err != nil {
+			described := describeDecodeError(err, 0)
+			// The field locates the element; an offset into it would
+			// not locate anything in the body.
+			described.Field, described.Offset = fmt.Sprintf("[%d]", i), 0
+			errs = append(errs, described)
+		}
+	}
+	if len(errs) > 0 {
+		writeBodyErrors(w, errs)
+		return nil, false
+	}
+	return bodies, true
+}
+
+var errTrailingData = errors.New("unexpected data after the JSON value")
+
+// describeDecodeError turns an encoding/json error into a message that
+// names the offending field or byte rather than Go types.
+func describeDecodeError(err error, offset int64) schemaError {
+	var syntax *json.SyntaxError
+	var wrongType *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return schemaError{Message: "body is empty, expected a JSON value"}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return schemaError{Message: "body ends before the JSON value is complete", Offset: offset}
+	case errors.As(err, &syntax):
+		return schemaError{Message: fmt.Sprintf("malformed JSON: %s", syntax.Error()), Offset: syntax.Offset}
+	case errors.As(err, &wrongType):
+		return schemaError{
+			Field:   fieldPath(wrongType.Field),
+			Message: fmt.Sprintf("must be %s, got %s", jsonKind(wrongType.Type), wrongType.Value),
+			Offset:  wrongType.Offset,
+		}
+	case errors.Is(err, errTrailingData):
+		return schemaError{Message: err.Error(), Offset: offset}
+	}
+	return schemaError{Message: err.Error()}
+}
+
+// fieldPath rewrites encoding/json's dotted field paths, such as "1.q",
+// into the notation schema errors use, "[1].q".
+func fieldPath(field string) string {
+	var path strings.Builder
+	for i, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			fmt.Fprintf(&path, "[%s]", part)
+			continue
+		}
+		if i > 0 {
+			path.WriteByte('.')
+		}
+		path.WriteString(part)
+	}
+	return path.String()
+}
+
+// jsonKind names the JSON value a Go type is decoded from.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "a number"
+	}
+	return "a " + t.String()
+}
+
+// writeBodyErrors answers 400 with errs as a JSON list.
+func writeBodyErrors(w http.ResponseWriter, errs []schemaError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(schemaErrorResponse{Errors: errs})
+}
+
+type schemaErrorResponse struct {
+	Errors []schemaError `json:"errors"`
+}
+
+// validateBodies checks each body against searchBodySchema, writing every
+// failure as a 400 JSON response. Fields are prefixed with the body's index
+// when batched.
+func validateBodies(w http.ResponseWriter, bodies []searchBody, batched bool) bool {
+	var errs []schemaError
+	for i, body := range bodies {
+		for _, err := range searchBodySchema.validate(map[string]any(body)) {
+			if batched {
+				err.Field = fmt.Sprintf("[%d].%s", i, err.Field)
+			}
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return true
+	}
+	writeBodyErrors(w, errs)
+	return false
+}
+
+// postSearchHandler runs a search described by a JSON body and always
+// answers with the JSON envelope.
+func postSearchHandler(w http.ResponseWriter, r *http.Request) {
+	var body searchBody
+	if !decodeBody(w, r, &body) || !validateBodies(w, []searchBody{body}, false) {
+		return
+	}
+	params, err := body.params()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query, opts, err := parseSearchParams(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Lang = negotiateLanguage(r.Header.Get("Accept-Language"))
+
+	ctx, cancel := context.WithTimeout(r.Context(), searchBudget)
+	defer cancel()
+	response, err := paginate(searchProperties(ctx, query, opts), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Language", opts.Lang)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// batchSearchHandler runs a JSON array of searches and answers with their
+// envelopes in the same order. The whole batch shares one search budget.
+func batchSearchHandler(w http.ResponseWriter, r *http.Request) {
+	bodies, ok := decodeBatch(w, r)
+	if !ok {
+		return
+	}
+	if len(bodies) == 0 || len(bodies) > maxBatchSize {
+		http.Error(w, fmt.Sprintf("A batch must contain between 1 and %d searches", maxBatchSize), http.StatusBadRequest)
+		return
+	}
+	if !validateBodies(w, bodies, true) {
+		return
+	}
+
+	lang := negotiateLanguage(r.Header.Get("Accept-Language"))
+	type search struct {
+		query string
+		opts  searchOptions
+	}
+	searches := make([]search, len(bodies))
+	for i, body := range bodies {
+		params, err := body.params()
+		if err == nil {
+			searches[i].query, searches[i].opts, err = parseSearchParams(params)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Search %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		searches[i].opts.Lang = lang
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), searchBudget)
+	defer cancel()
+	responses := make([]SearchResponse, len(searches))
+	for i, s := range searches {
+		response, err := paginate(searchProperties(ctx, s.query, s.opts), s.opts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Search %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+		responses[i] = response
+	}
+
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(responses)
+}
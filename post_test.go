@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// postJSON runs body through handler as a POST to path.
+func postJSON(handler http.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	handler(rec, req)
+	return rec
+}
+
+func TestPostBodySizeLimit(t *testing.T) {
+	withFreshCache(t)
+	previous := maxBodyBytes
+	maxBodyBytes = 64
+	t.Cleanup(func() { maxBodyBytes = previous })
+
+	padded := `{"q": "udaipur", "name": "` + strings.Repeat("a", 64) + `"}`
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		path, body string
+		wantStatus int
+	}{
+		{"search within limit", postSearchHandler, "/search", `{"q": "udaipur"}`, http.StatusOK},
+		{"search over limit", postSearchHandler, "/search", padded, http.StatusRequestEntityTooLarge},
+		{"batch within limit", batchSearchHandler, "/search/batch", `[{"q": "udaipur"}]`, http.StatusOK},
+		{"batch over limit", batchSearchHandler, "/search/batch", `[` + padded + `]`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		if rec := postJSON(tt.handler, tt.path, tt.body); rec.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.wantStatus, rec.Body.String())
+		}
+	}
+}
+
+// bodyErrorFields decodes a 400 schema error response into its fields.
+func bodyErrorFields(t *testing.T, rec *httptest.ResponseRecorder) []string {
+	t.Helper()
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+	var response schemaErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+	}
+	fields := []string{}
+	for _, err := range response.Errors {
+		if err.Message == "" {
+			t.Errorf("error for %q has no message", err.Field)
+		}
+		fields = append(fields, err.Field)
+	}
+	return fields
+}
+
+func TestPostSearchSchemaValidation(t *testing.T) {
+	tests := []struct {
+		name, body string
+		wantFields []string
+	}{
+		{"unknown field", `{"q": "udaipur", "raduis": 10}`, []string{"raduis"}},
+		{"wrong type", `{"q": 42}`, []string{"q"}},
+		{"enum", `{"q": "udaipur", "unit": "ft"}`, []string{"unit"}},
+		{"below minimum", `{"q": "udaipur", "radius": 0, "month": 13}`, []string{"month", "radius"}},
+		{"non-integer", `{"q": "udaipur", "limit": 2.5}`, []string{"limit"}},
+		{"bad list item", `{"q": "udaipur", "radii": [5, -1, "x"]}`, []string{"radii[1]", "radii[2]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := bodyErrorFields(t, postJSON(postSearchHandler, "/search", tt.body))
+			if !slices.Equal(got, tt.wantFields) {
+				t.Errorf("errors for %v, want %v", got, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestBatchSearchSchemaValidation(t *testing.T) {
+	rec := postJSON(batchSearchHandler, "/search/batch", `[{"q": "udaipur"}, {"q": "jaipur", "sort": "price"}, {"bogus": true}]`)
+	if got, want := bodyErrorFields(t, rec), []string{"[1].sort", "[2].bogus"}; !slices.Equal(got, want) {
+		t.Errorf("errors for %v, want %v", got, want)
+	}
+}
+
+func TestPostSearchDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name, body  string
+		handler     http.HandlerFunc
+		wantField   string
+		wantMessage string
+		wantOffset  int64
+	}{
+		{"empty", ``, postSearchHandler, "", "body is empty", 0},
+		{"truncated", `{"q": "udai`, postSearchHandler, "", "ends before the JSON value is complete", 11},
+		{"syntax", `{"q" "udaipur"}`, postSearchHandler, "", "malformed JSON", 6},
+		{"trailing data", `{"q": "udaipur"} {}`, postSearchHandler, "", "unexpected data", 17},
+		{"not an object", `["udaipur"]`, postSearchHandler, "", "must be an object, got array", 1},
+		{"wrong-typed batch item", `[{"q": "udaipur"}, "jaipur"]`, batchSearchHandler, "[1]", "must be an object, got string", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postJSON(tt.handler, "/search", tt.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+			}
+			var response schemaErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || len(response.Errors) != 1 {
+				t.Fatalf("body %q is not a single structured error: %v", rec.Body.String(), err)
+			}
+			got := response.Errors[0]
+			if got.Field != tt.wantField || !strings.Contains(got.Message, tt.wantMessage) || got.Offset != tt.wantOffset {
+				t.Errorf("got %+v, want field %q, a message containing %q and offset %d", got, tt.wantField, tt.wantMessage, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestFieldPath(t *testing.T) {
+	tests := map[string]string{
+		"":        "",
+		"q":       "q",
+		"1":       "[1]",
+		"1.q":     "[1].q",
+		"radii.0": "radii[0]",
+	}
+	for field, want := range tests {
+		if got := fieldPath(field); got != want {
+			t.Errorf("fieldPath(%q) = %q, want %q", field, got, want)
+		}
+	}
+}
+
+func TestBatchSearchDecodeErrors(t *testing.T) {
+	rec := postJSON(batchSearchHandler, "/search/batch", `[{"q": "udaipur"}, 42, {"q": "jaipur"}, ["goa"]]`)
+	if got, want := bodyErrorFields(t, rec), []string{"[1]", "[3]"}; !slices.Equal(got, want) {
+		t.Errorf("errors for %v, want %v", got, want)
+	}
+	rec = postJSON(batchSearchHandler, "/search/batch", `{"q": "udaipur"}`)
+	if got, want := bodyErrorFields(t, rec), []string{""}; !slices.Equal(got, want) {
+		t.Errorf("errors for %v, want %v for a body that is not an array", got, want)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type rankedProperty struct {
+	Property
+	Distance float64
+}
+
+func (p rankedProperty) response() PropertyResponse {
+	return PropertyResponse{
+		Name:       p.Name,
+		Distance:   p.Distance,
+		Latitude:   truncateCoordinate(p.Latitude),
+		Longitude:  truncateCoordinate(p.Longitude),
+		UpdatedAt:  p.UpdatedAt,
+		Rating:     p.Rating,
+		Attributes: p.Attributes,
+		Type:       p.propertyType(),
+	}
+}
+
+// rankProperties returns every property with its distance from the given
+// point, nearest first. If ctx is done before every property has been
+// measured, it ranks the ones measured so far and reports truncated.
+func rankProperties(ctx context.Context, lat, lon float64, distance distanceFunc) (results []rankedProperty, truncated bool) {
+	props := currentProperties()
+	results = make([]rankedProperty, 0, len(props))
+	for _, prop := range props {
+		if ctx.Err() != nil {
+			truncated = true
+			break
+		}
+		results = append(results, rankedProperty{
+			Property: prop,
+			Distance: distance(lat, lon, prop.Latitude, prop.Longitude),
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Distance < results[j].Distance
+	})
+	return results, truncated
+}
+
+func distanceFormula(name string) distanceFunc {
+	if name == "" {
+		return calculateDistance
+	}
+	return distanceFormulas[name]
+}
+
+// rankingKey identifies a memoized ranking. Only city centers and
+// properties are memoized, since both are bounded sets while geocoded
+// origins are not.
+type rankingKey struct {
+	City     string
+	Property string
+	Formula  string
+}
+
+// Rankings are memoized separately from whole responses so that searches
+// differing only in radius, limit or filters share one distance
+// computation. Searches from a city center are the common case: with the
+// memo warm, BenchmarkSearch has a response-cache miss for "udaipur" drop
+// from about 20µs to 8µs with the built-in catalog, and from about 1.3ms to
+// 11µs with 2,000 properties. Memoized slices are shared and must not be
+// modified.
+var (
+	rankingMemo           = make(map[rankingKey][]rankedProperty)
+	rankingMemoGeneration int
+	rankingMemoMu         sync.RWMutex
+)
+
+// memoizedRanking returns rankProperties' result for origin, computing it
+// only the first time it is needed for the current catalog.
+func memoizedRanking(ctx context.Context, origin resolvedOrigin, formula string) ([]rankedProperty, bool) {
+	key, memoizable := rankingKeyFor(origin, formula)
+	if !memoizable {
+		return rankProperties(ctx, origin.Lat, origin.Lon, distanceFormula(formula))
+	}
+
+	rankingMemoMu.RLock()
+	ranked, exists := rankingMemo[key]
+	generation := rankingMemoGeneration
+	rankingMemoMu.RUnlock()
+	if exists {
+		return ranked, false
+	}
+
+	ranked, truncated := rankProperties(ctx, origin.Lat, origin.Lon, distanceFormula(formula))
+	if !truncated {
+		rankingMemoMu.Lock()
+		if rankingMemoGeneration == generation {
+			rankingMemo[key] = ranked
+		}
+		rankingMemoMu.Unlock()
+	}
+	return ranked, truncated
+}
+
+func rankingKeyFor(origin resolvedOrigin, formula string) (rankingKey, bool) {
+	key := rankingKey{City: origin.City, Formula: formula}
+	if origin.FromProperty {
+		key.Property = origin.Label
+	}
+	return key, key.City != "" || key.Property != ""
+}
+
+// isRankingMemoized reports whether memoizedRanking would answer from the
+// memo without measuring any distances.
+func isRankingMemoized(origin resolvedOrigin, formula string) bool {
+	key, memoizable := rankingKeyFor(origin, formula)
+	if !memoizable {
+		return false
+	}
+	rankingMemoMu.RLock()
+	defer rankingMemoMu.RUnlock()
+	_, exists := rankingMemo[key]
+	return exists
+}
+
+func resetRankings() {
+	rankingMemoMu.Lock()
+	defer rankingMemoMu.Unlock()
+	rankingMemo = make(map[rankingKey][]rankedProperty)
+	rankingMemoGeneration++
+}
+
+// warmCityRankings memoizes the great-circle ranking of every city center.
+func warmCityRankings() {
+	for city, coords := range cityCenters {
+		memoizedRanking(context.Background(), resolvedOrigin{Lat: coords.Lat, Lon: coords.Lon, Label: city, City: city}, "")
+	}
+}
+
+// resolveAnchors looks up the properties named in a searchOptions.Near
+// list, skipping any that have left the catalog since it was parsed.
+func resolveAnchors(names string) []resolvedOrigin {
+	var anchors []resolvedOrigin
+	for _, name := range strings.Split(names, ",") {
+		if prop, exists := findPropertyByName(name); exists {
+			anchors = append(anchors, resolvedOrigin{Lat: prop.Latitude, Lon: prop.Longitude, Label: prop.Name, FromProperty: true})
+		}
+	}
+	return anchors
+}
+
+func isAnchor(anchors []resolvedOrigin, prop Property) bool {
+	for _, anchor := range anchors {
+		if anchor.Label == prop.Name && anchor.Lat == prop.Latitude && anchor.Lon == prop.Longitude {
+			return true
+		}
+	}
+	return false
+}
+
+// rankNearAnchors ranks every property by its distance to the nearest
+// anchor and reports, by property name, the anchors each is within radius
+// of.
+func rankNearAnchors(ctx context.Context, anchors []resolvedOrigin, radius float64, opts searchOptions) ([]rankedProperty, map[string][]string, bool) {
+	nearest := make(map[string]rankedProperty)
+	near := make(map[string][]string)
+	var order []string
+	truncated := false
+	for _, anchor := range anchors {
+		ranked, cut := memoizedRanking(ctx, anchor, opts.Formula)
+		truncated = truncated || cut
+		for _, result := range ranked {
+			best, seen := nearest[result.Name]
+			if !seen {
+				order = append(order, result.Name)
+			}
+			if !seen || result.Distance < best.Distance {
+				nearest[result.Name] = result
+			}
+			if withinRadius(result.Distance, radius, opts.ExclusiveBoundary) {
+				near[result.Name] = append(near[result.Name], anchor.Label)
+			}
+		}
+	}
+
+	merged := make([]rankedProperty, 0, len(order))
+	for _, name := range order {
+		merged = append(merged, nearest[name])
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Distance < merged[j].Distance
+	})
+	return merged, near, truncated
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"testing"
+)
+
+// syntheticCatalog returns n properties scattered over India, the same
+// ones on every call.
+func syntheticCatalog(n int) []Property {
+	random := rand.New(rand.NewPCG(1, 2))
+	props := make([]Property, n)
+	for i := range props {
+		props[i] = Property{
+			Name:      fmt.Sprintf("Synthetic Stay %d", i),
+			Latitude:  8 + random.Float64()*27,
+			Longitude: 68 + random.Float64()*29,
+		}
+	}
+	return props
+}
+
+// quietLogs discards log output for the duration of a benchmark.
+func quietLogs(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// BenchmarkSearch measures a response-cache miss for an exact city hit,
+// with the ranking memoized and with it recomputed every time.
+func BenchmarkSearch(b *testing.B) {
+	quietLogs(b)
+	catalogs := []struct {
+		name  string
+		props []Property
+	}{
+		{"builtin", currentProperties()},
+		{"2000", syntheticCatalog(2000)},
+	}
+	opts := searchOptions{RadiusKm: defaultRadiusKm}
+	for _, catalog := range catalogs {
+		for _, memoized := range []bool{true, false} {
+			b.Run(fmt.Sprintf("catalog=%s/memoized=%v", catalog.name, memoized), func(b *testing.B) {
+				withCatalog(b, catalog.props)
+				resetRankings()
+				computeSearch(context.Background(), "udaipur", opts, "")
+				for b.Loop() {
+					if !memoized {
+						resetRankings()
+					}
+					computeSearch(context.Background(), "udaipur", opts, "")
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkRadiusChanges compares searches that differ only in radius, so
+// that every one misses the response cache, with and without the ranking
+// memo, against repeating one search so that the response cache answers.
+func BenchmarkRadiusChanges(b *testing.B) {
+	quietLogs(b)
+	withCatalog(b, syntheticCatalog(2000))
+	cases := []struct {
+		name       string
+		memoized   bool
+		sameRadius bool
+	}{
+		{"response-cache-miss/memoized", true, false},
+		{"response-cache-miss/recomputed", false, false},
+		{"response-cache-hit", true, true},
+	}
+	for _, tc := range cases {
+		b.Run(tc.name, func(b *testing.B) {
+			withFreshCache(b)
+			resetRankings()
+			radius := 1.0
+			for b.Loop() {
+				if !tc.memoized {
+					resetRankings()
+				}
+				if !tc.sameRadius {
+					radius += 0.001
+				}
+				searchProperties(context.Background(), "udaipur", searchOptions{RadiusKm: radius})
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// startupCheckMode decides what happens when a configured dependency is
+// still unreachable once startupCheckTimeout has passed: "fail" exits,
+// "degrade" serves anyway with /readyz reporting 503 until it recovers, and
+// "off" skips the checks.
+var (
+	startupCheckMode    = "degrade"
+	startupCheckTimeout = 10 * time.Second
+	// dependencyCheckTimeout bounds each attempt of a check, at startup and
+	// from /readyz.
+	dependencyCheckTimeout = 2 * time.Second
+	// readinessTTL is how long /readyz reuses the outcome of a check before
+	// running it again.
+	readinessTTL = 5 * time.Second
+)
+
+var startupCheckModes = []string{"fail", "degrade", "off"}
+
+// dependencyCheck probes one external dependency the server needs to serve
+// correctly.
+type dependencyCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// pinger is implemented by caches whose backend can be unreachable.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// geocoderProbeQuery is looked up to check that the geocoder answers; any
+// answer, found or not, will do.
+const geocoderProbeQuery = "udaipur"
+
+// dependencyChecks lists the configured dependencies: the Redis cache and
+// the geocoder. A geocoder that answers "not found" is reachable.
+func dependencyChecks() []dependencyCheck {
+	var checks []dependencyCheck
+	if cache, ok := searchCache.(pinger); ok {
+		checks = append(checks, dependencyCheck{Name: "cache", Check: cache.Ping})
+	}
+	if geocoder != nil {
+		checks = append(checks, dependencyCheck{Name: "geocoder", Check: func(ctx context.Context) error {
+			_, err := geocoder.Geocode(ctx, geocoderProbeQuery)
+			if errors.Is(err, errPlaceNotFound) {
+				return nil
+			}
+			return err
+		}})
+	}
+	return checks
+}
+
+// checkResult is the outcome of a dependency check and when it ran.
+type checkResult struct {
+	err     error
+	checked time.Time
+	// running is set while /readyz is running the check again.
+	running bool
+}
+
+// readiness holds the latest result of each dependency check, by name.
+var readiness = struct {
+	mu      sync.Mutex
+	checks  []dependencyCheck
+	results map[string]*checkResult
+}{results: make(map[string]*checkResult)}
+
+func runCheck(check dependencyCheck) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dependencyCheckTimeout)
+	defer cancel()
+	return check.Check(ctx)
+}
+
+// waitForDependencies retries each dependency check until it passes or
+// startupCheckTimeout runs out, logging the outcome of each, and reports
+// whether all of them passed.
+func waitForDependencies() bool {
+	checks := dependencyChecks()
+	deadline := time.Now().Add(startupCheckTimeout)
+	ready := true
+	for _, check := range checks {
+		err := runCheck(check)
+		for err != nil && time.Now().Add(time.Second).Before(deadline) {
+			time.Sleep(time.Second)
+			err = runCheck(check)
+		}
+		if err != nil {
+			log.Printf("Startup check %s failed: %v", check.Name, err)
+			ready = false
+		} else {
+			log.Printf("Startup check %s passed", check.Name)
+		}
+		readiness.mu.Lock()
+		readiness.results[check.Name] = &checkResult{err: err, checked: time.Now()}
+		readiness.mu.Unlock()
+	}
+	readiness.mu.Lock()
+	readiness.checks = checks
+	readiness.mu.Unlock()
+	return ready
+}
+
+type readyResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// readyHandler reports 200 once every dependency check has passed and 503
+// while any is failing. Checks older than readinessTTL are run again, passing
+// or not, so that a degraded server becomes ready when its dependencies
+// recover and a ready one notices when they go away. They run outside the
+// lock, and requests arriving meanwhile get the previous results.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	refreshReadiness()
+
+	readiness.mu.Lock()
+	response := readyResponse{Status: "ready", Checks: make(map[string]string)}
+	for _, check := range readiness.checks {
+		if err := readiness.results[check.Name].err; err != nil {
+			response.Status = "degraded"
+			response.Checks[check.Name] = err.Error()
+		} else {
+			response.Checks[check.Name] = "ok"
+		}
+	}
+	readiness.mu.Unlock()
+
+	status := http.StatusOK
+	if response.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// refreshReadiness runs the checks whose result has expired, unless another
+// request already is, and stores their new results.
+func refreshReadiness() {
+	readiness.mu.Lock()
+	var due []dependencyCheck
+	for _, check := range readiness.checks {
+		result := readiness.results[check.Name]
+		if !result.running && time.Since(result.checked) >= readinessTTL {
+			result.running = true
+			due = append(due, check)
+		}
+	}
+	readiness.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, check := range due {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := runCheck(check)
+			readiness.mu.Lock()
+			readiness.results[check.Name] = &checkResult{err: err, checked: time.Now()}
+			readiness.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withReadiness makes checks, with their results as of now, what /readyz
+// reports on for the duration of a test.
+func withReadiness(t *testing.T, ttl time.Duration, checks []dependencyCheck, errs map[string]error) {
+	t.Helper()
+	readiness.mu.Lock()
+	previousChecks, previousResults, previousTTL := readiness.checks, readiness.results, readinessTTL
+	readiness.checks = checks
+	readiness.results = make(map[string]*checkResult)
+	for _, check := range checks {
+		readiness.results[check.Name] = &checkResult{err: errs[check.Name], checked: time.Now()}
+	}
+	readinessTTL = ttl
+	readiness.mu.Unlock()
+	t.Cleanup(func() {
+		readiness.mu.Lock()
+		readiness.checks, readiness.results, readinessTTL = previousChecks, previousResults, previousTTL
+		readiness.mu.Unlock()
+	})
+}
+
+func getReady(t *testing.T) (int, readyResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	readyHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var response readyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, response
+}
+
+func TestReadyRechecksExpiredResults(t *testing.T) {
+	var cacheDown atomic.Bool
+	var runs atomic.Int32
+	checks := []dependencyCheck{{Name: "cache", Check: func(ctx context.Context) error {
+		runs.Add(1)
+		if cacheDown.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}}}
+
+	cacheDown.Store(true)
+	withReadiness(t, 0, checks, map[string]error{"cache": errors.New("connection refused")})
+	if code, response := getReady(t); code != http.StatusServiceUnavailable || response.Checks["cache"] != "connection refused" {
+		t.Errorf("with the cache down: %d %+v, want 503 naming the error", code, response)
+	}
+
+	cacheDown.Store(false)
+	if code, response := getReady(t); code != http.StatusOK || response.Status != "ready" {
+		t.Errorf("after the cache recovered: %d %+v, want 200 ready", code, response)
+	}
+
+	// A check that passed is run again too.
+	cacheDown.Store(true)
+	if code, _ := getReady(t); code != http.StatusServiceUnavailable {
+		t.Errorf("after the cache went away again: status %d, want 503", code)
+	}
+	if got := runs.Load(); got != 3 {
+		t.Errorf("check ran %d times, want once per request", got)
+	}
+}
+
+func TestReadyCachesResults(t *testing.T) {
+	var runs atomic.Int32
+	checks := []dependencyCheck{{Name: "geocoder", Check: func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	}}}
+	withReadiness(t, time.Hour, checks, map[string]error{"geocoder": errors.New("timeout")})
+	for range 3 {
+		if code, _ := getReady(t); code != http.StatusServiceUnavailable {
+			t.Errorf("status %d, want the cached 503", code)
+		}
+	}
+	if got := runs.Load(); got != 0 {
+		t.Errorf("check ran %d times within its TTL, want 0", got)
+	}
+}
+
+func TestReadyDoesNotWaitForRunningChecks(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	checks := []dependencyCheck{{Name: "geocoder", Check: func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}}}
+	withReadiness(t, 0, checks, map[string]error{"geocoder": errors.New("timeout")})
+
+	first := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		readyHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+		first <- rec.Code
+	}()
+	<-started
+
+	// The slow check holds no lock, so this answers at once from the
+	// previous result instead of running the check a second time.
+	if code, _ := getReady(t); code != http.StatusServiceUnavailable {
+		t.Errorf("while the check runs: status %d, want the previous 503", code)
+	}
+	close(release)
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("request that ran the check: status %d, want 200", code)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Region is the administrative area a property is in.
+type Region struct {
+	// Country is the ISO 3166-1 alpha-2 code.
+	Country string `json:"country"`
+	State   string `json:"state,omitempty"`
+}
+
+// RegionResolver reverse geocodes coordinates to their Region.
+type RegionResolver interface {
+	Region(ctx context.Context, coords Coordinates) (Region, error)
+}
+
+// regionResolver is nil unless -region-url is configured. Properties whose
+// catalog attributes carry "country" (and optionally "state") are enriched
+// without it.
+var regionResolver RegionResolver
+
+// regionTimeout bounds the region lookups of one search.
+var regionTimeout = time.Second
+
+// httpRegionResolver calls a JSON endpoint built from a URL template in
+// which {lat} and {lon} are replaced by the coordinates. The endpoint
+// answers {"country": "IN", "state": "Rajasthan"}.
+type httpRegionResolver struct {
+	urlTemplate string
+	client      *http.Client
+}
+
+func newHTTPRegionResolver(urlTemplate string) *httpRegionResolver {
+	return &httpRegionResolver{urlTemplate: urlTemplate, client: &http.Client{}}
+}
+
+func (g *httpRegionResolver) Region(ctx context.Context, coords Coordinates) (Region, error) {
+	target := strings.NewReplacer(
+		"{lat}", strconv.FormatFloat(coords.Lat, 'f', -1, 64),
+		"{lon}", strconv.FormatFloat(coords.Lon, 'f', -1, 64),
+	).Replace(g.urlTemplate)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return Region{}, err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return Region{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Region{}, fmt.Errorf("region provider returned %s", resp.Status)
+	}
+	var region Region
+	if err := json.NewDecoder(resp.Body).Decode(&region); err != nil {
+		return Region{}, fmt.Errorf("decoding region response: %w", err)
+	}
+	if region.Country == "" {
+		return Region{}, fmt.Errorf("region provider returned no country")
+	}
+	region.Country = strings.ToUpper(region.Country)
+	return region, nil
+}
+
+// regionCache remembers resolved regions by exact coordinates. Property
+// locations rarely change, so entries never expire; failures are not
+// cached and are retried by the next enriched search.
+var regionCache = struct {
+	mu      sync.Mutex
+	regions map[Coordinates]Region
+}{regions: make(map[Coordinates]Region)}
+
+// propertyRegion returns the region of a property from its catalog
+// attributes or the cache, and otherwise from regionResolver if resolve is
+// set. Its error is the resolver's.
+func propertyRegion(ctx context.Context, prop Property, resolve bool) (Region, bool, error) {
+	if country := prop.attribute("country"); country != "" {
+		return Region{Country: strings.ToUpper(country), State: prop.attribute("state")}, true, nil
+	}
+	coords := Coordinates{Lat: prop.Latitude, Lon: prop.Longitude}
+	regionCache.mu.Lock()
+	region, exists := regionCache.regions[coords]
+	regionCache.mu.Unlock()
+	if exists || !resolve || regionResolver == nil {
+		return region, exists, nil
+	}
+
+	region, err := regionResolver.Region(ctx, coords)
+	if err != nil {
+		return Region{}, false, err
+	}
+	regionCache.mu.Lock()
+	regionCache.regions[coords] = region
+	regionCache.mu.Unlock()
+	return region, true, nil
+}
+
+// enrichRegions adds each property's region to a copy of the response's
+// properties for enrich=true, leaving out those whose region is unknown.
+// Once the provider has failed, the remaining lookups fall back to the
+// catalog and cache only, so a down provider costs one timeout per search.
+func enrichRegions(ctx context.Context, response SearchResponse, opts searchOptions) SearchResponse {
+	if !opts.Enrich || len(response.Properties) == 0 {
+		return response
+	}
+	props := make(map[string]Property)
+	for _, prop := range currentProperties() {
+		props[prop.Name] = prop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, regionTimeout)
+	defer cancel()
+	resolve := true
+	enriched := make([]PropertyResponse, len(response.Properties))
+	copy(enriched, response.Properties)
+	for i, item := range enriched {
+		prop, exists := props[item.Name]
+		if !exists {
+			continue
+		}
+		region, ok, err := propertyRegion(ctx, prop, resolve)
+		if err != nil {
+			log.Printf("Region lookup for %q failed, skipping the provider for the rest of this search: %v", prop.Name, err)
+			resolve = false
+		}
+		if ok {
+			enriched[i].Region = &region
+		}
+	}
+	response.Properties = enriched
+	return response
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// routerConfig holds the settings newRouter builds the routes from.
+type routerConfig struct {
+	// BasePath prefixes every route; empty serves them at the root.
+	BasePath string
+	// ProbesAtRoot also serves /healthz, /readyz and /metrics without
+	// BasePath.
+	ProbesAtRoot bool
+	// APIKeys are the keys searches must present; nil lets every request
+	// through.
+	APIKeys map[string]bool
+	// AdminToken guards the /admin routes.
+	AdminToken string
+}
+
+// newRouter wires every route of the API.
+func newRouter(cfg routerConfig) http.Handler {
+	root := mux.NewRouter().StrictSlash(true)
+	r := root
+	if cfg.BasePath != "" {
+		r = root.PathPrefix(cfg.BasePath).Subrouter()
+	}
+
+	authenticated := requireAPIKey(cfg.APIKeys)
+	limited := limitConcurrency(maxConcurrentSearches)
+	metered := dailyQuota(dailyQuotaLimit)
+	r.HandleFunc("/search", authenticated(metered(limited(searchHandler)))).Methods("GET")
+	r.HandleFunc("/search", authenticated(metered(limited(postSearchHandler)))).Methods("POST")
+	r.HandleFunc("/search/batch", authenticated(metered(limited(batchSearchHandler)))).Methods("POST")
+	r.HandleFunc("/closest", authenticated(metered(closestHandler))).Methods("GET")
+	r.HandleFunc("/reverse", authenticated(metered(reverseHandler))).Methods("GET")
+	r.HandleFunc("/suggest-radius", authenticated(metered(suggestRadiusHandler))).Methods("GET")
+	r.HandleFunc("/healthz", healthHandler).Methods("GET")
+	r.HandleFunc("/readyz", readyHandler).Methods("GET")
+	r.HandleFunc("/properties/grouped", authenticated(metered(groupedPropertiesHandler))).Methods("GET")
+	r.HandleFunc("/density", authenticated(metered(densityHandler))).Methods("GET")
+	r.HandleFunc("/coverage", authenticated(metered(coverageHandler))).Methods("GET")
+	r.HandleFunc("/hull", authenticated(metered(hullHandler))).Methods("GET")
+	r.HandleFunc("/analyze", authenticated(metered(analyzeHandler))).Methods("GET")
+	r.HandleFunc("/lucky", authenticated(metered(limited(luckyHandler)))).Methods("GET")
+	r.HandleFunc("/stats", statsHandler).Methods("GET")
+	r.HandleFunc("/cities", citiesHandler).Methods("GET")
+	r.HandleFunc("/metrics", metricsHandler).Methods("GET")
+	if cfg.BasePath != "" && cfg.ProbesAtRoot {
+		root.HandleFunc("/healthz", healthHandler).Methods("GET")
+		root.HandleFunc("/readyz", readyHandler).Methods("GET")
+		root.HandleFunc("/metrics", metricsHandler).Methods("GET")
+	}
+	r.HandleFunc("/matrix", authenticated(metered(limited(matrixHandler)))).Methods("GET")
+	r.HandleFunc("/validate", authenticated(metered(validateHandler))).Methods("GET")
+
+	admin := requireAdminToken(cfg.AdminToken)
+	r.HandleFunc("/admin/cache", admin(cacheContentsHandler)).Methods("GET")
+	r.HandleFunc("/admin/cache", admin(cacheFlushHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/searches", admin(searchCountsHandler)).Methods("GET")
+	return trimTrailingSlash(root)
+}
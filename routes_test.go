@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouterTrailingSlashes(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          routerConfig
+		method, path string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"get", routerConfig{}, "GET", "/search?q=udaipur", http.StatusOK, ""},
+		{"get with slash", routerConfig{}, "GET", "/search/?q=udaipur", http.StatusMovedPermanently, "/search?q=udaipur"},
+		{"post", routerConfig{}, "POST", "/search", http.StatusOK, ""},
+		{"post with slash", routerConfig{}, "POST", "/search/", http.StatusOK, ""},
+		{"post with slashes", routerConfig{}, "POST", "/search//", http.StatusOK, ""},
+		{"batch with slash", routerConfig{}, "POST", "/search/batch/", http.StatusOK, ""},
+
+		{"base path get", routerConfig{BasePath: "/api/v1"}, "GET", "/api/v1/search?q=udaipur", http.StatusOK, ""},
+		{"base path get with slash", routerConfig{BasePath: "/api/v1"}, "GET", "/api/v1/search/?q=udaipur", http.StatusMovedPermanently, "/api/v1/search?q=udaipur"},
+		{"base path post with slash", routerConfig{BasePath: "/api/v1"}, "POST", "/api/v1/search/", http.StatusOK, ""},
+		{"base path route at root", routerConfig{BasePath: "/api/v1"}, "GET", "/search?q=udaipur", http.StatusNotFound, ""},
+		{"probe under base path", routerConfig{BasePath: "/api/v1"}, "GET", "/api/v1/healthz", http.StatusOK, ""},
+		{"probe at root without the flag", routerConfig{BasePath: "/api/v1"}, "GET", "/healthz", http.StatusNotFound, ""},
+		{"probe at root", routerConfig{BasePath: "/api/v1", ProbesAtRoot: true}, "GET", "/healthz/", http.StatusMovedPermanently, "/healthz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFreshCache(t)
+			body := `{"q": "udaipur"}`
+			if strings.Contains(tt.path, "batch") {
+				body = `[{"q": "udaipur"}]`
+			}
+			rec := httptest.NewRecorder()
+			newRouter(tt.cfg).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if location := rec.Header().Get("Location"); location != tt.wantLocation {
+				t.Errorf("Location %q, want %q", location, tt.wantLocation)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// searchBodySchemaJSON is the JSON Schema POST search bodies are checked
+// against before their values are parsed.
+//
+//go:embed search.schema.json
+var searchBodySchemaJSON []byte
+
+var searchBodySchema = mustParseSchema(searchBodySchemaJSON)
+
+// jsonSchema is the subset of JSON Schema the embedded schemas use.
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type"`
+	Enum                 []any                  `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum"`
+	Items                *jsonSchema            `json:"items"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+}
+
+// schemaTypes accepts both "type": "string" and "type": ["string", "array"].
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+func mustParseSchema(data []byte) *jsonSchema {
+	var schema jsonSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		panic(fmt.Sprintf("parsing embedded schema: %v", err))
+	}
+	return &schema
+}
+
+// schemaError locates one validation failure. Field is a path such as
+// "radii[1]", empty for the document itself. Offset is the byte position
+// of syntax errors.
+type schemaError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	Offset  int64  `json:"offset,omitempty"`
+}
+
+// validate checks value, as decoded by encoding/json, against s and
+// returns every failure found, ordered by field.
+func (s *jsonSchema) validate(value any) []schemaError {
+	var errs []schemaError
+	s.check("", value, &errs)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+	return errs
+}
+
+func (s *jsonSchema) check(path string, value any, errs *[]schemaError) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, schemaError{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return hasSchemaType(value, t) }) {
+		fail("must be of type %s", strings.Join(s.Type, " or "))
+		return
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, value) {
+		allowed := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			allowed[i] = fmt.Sprint(v)
+		}
+		fail("must be one of %s", strings.Join(allowed, ", "))
+		return
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("must be at least %g", *s.Minimum)
+		}
+		if s.ExclusiveMinimum != nil && v <= *s.ExclusiveMinimum {
+			fail("must be above %g", *s.ExclusiveMinimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("must be at most %g", *s.Maximum)
+		}
+		if s.ExclusiveMaximum != nil && v >= *s.ExclusiveMaximum {
+			fail("must be below %g", *s.ExclusiveMaximum)
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.check(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case map[string]any:
+		for key, item := range v {
+			field := key
+			if path != "" {
+				field = path + "." + key
+			}
+			if property, known := s.Properties[key]; known {
+				property.check(field, item, errs)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				*errs = append(*errs, schemaError{Field: field, Message: "is not a known field"})
+			}
+		}
+	}
+}
+
+func hasSchemaType(value any, schemaType string) bool {
+	switch v := value.(type) {
+	case string:
+		return schemaType == "string"
+	case bool:
+		return schemaType == "boolean"
+	case float64:
+		return schemaType == "number" || (schemaType == "integer" && v == math.Trunc(v))
+	case []any:
+		return schemaType == "array"
+	case map[string]any:
+		return schemaType == "object"
+	case nil:
+		return schemaType == "null"
+	}
+	return false
+}
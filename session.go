@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// sessionTTL is how long a search session remembers its location after
+// its last use.
+var sessionTTL = 30 * time.Minute
+
+// sessionLocationParams are the parameters that choose a search's origin
+// and so are what a session remembers.
+var sessionLocationParams = []string{"q", "pin", "airport", "near"}
+
+func newSessionToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
+
+func hasLocation(params url.Values) bool {
+	for _, name := range sessionLocationParams {
+		if strings.TrimSpace(params.Get(name)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSessionLocation returns the location parameters of the session's
+// last search, or false if the session is unknown or expired.
+func loadSessionLocation(token string) (map[string]string, bool) {
+	stored, exists := searchCache.GetValue("session:" + token)
+	if !exists {
+		return nil, false
+	}
+	var location map[string]string
+	if err := json.Unmarshal([]byte(stored), &location); err != nil {
+		return nil, false
+	}
+	return location, true
+}
+
+// saveSessionLocation remembers the location parameters of params under
+// token, extending the session's lifetime.
+func saveSessionLocation(token string, params url.Values) {
+	location := make(map[string]string)
+	for _, name := range sessionLocationParams {
+		if value := params.Get(name); value != "" {
+			location[name] = value
+		}
+	}
+	data, _ := json.Marshal(location)
+	searchCache.SetValue("session:"+token, string(data), sessionTTL)
+}
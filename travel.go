@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TravelTimer estimates driving times from an origin to each destination.
+// A destination it cannot reach gets a negative duration.
+type TravelTimer interface {
+	TravelTimes(ctx context.Context, from Coordinates, to []Coordinates) ([]time.Duration, error)
+}
+
+// travelTimer is nil unless -travel-time-url is configured, in which case
+// sort=duration falls back to ordering by distance.
+var travelTimer TravelTimer
+
+// travelTimeTimeout bounds each call to travelTimer.
+var travelTimeTimeout = time.Second
+
+// httpTravelTimer calls a JSON endpoint built from a URL template in which
+// {from} is replaced by "lat,lon" and {to} by the destinations as
+// "lat,lon" joined with ";". The endpoint answers {"durations": [...]} in
+// seconds, in the order of the destinations, with null for unreachable ones.
+type httpTravelTimer struct {
+	urlTemplate string
+	client      *http.Client
+}
+
+func newHTTPTravelTimer(urlTemplate string) *httpTravelTimer {
+	return &httpTravelTimer{urlTemplate: urlTemplate, client: &http.Client{}}
+}
+
+func (t *httpTravelTimer) TravelTimes(ctx context.Context, from Coordinates, to []Coordinates) ([]time.Duration, error) {
+	points := make([]string, len(to))
+	for i, coords := range to {
+		points[i] = formatLatLon(coords)
+	}
+	target := strings.NewReplacer(
+		"{from}", url.QueryEscape(formatLatLon(from)),
+		"{to}", url.QueryEscape(strings.Join(points, ";")),
+	).Replace(t.urlTemplate)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("travel time provider returned %s", resp.Status)
+	}
+
+	var body struct {
+		Durations []*float64 `json:"durations"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding travel time response: %w", err)
+	}
+	if len(body.Durations) != len(to) {
+		return nil, fmt.Errorf("travel time provider returned %d durations for %d destinations", len(body.Durations), len(to))
+	}
+	durations := make([]time.Duration, len(to))
+	for i, seconds := range body.Durations {
+		durations[i] = -1
+		if seconds != nil && *seconds >= 0 {
+			durations[i] = time.Duration(*seconds * float64(time.Second))
+		}
+	}
+	return durations, nil
+}
+
+func formatLatLon(coords Coordinates) string {
+	return fmt.Sprintf("%g,%g", coords.Lat, coords.Lon)
+}
+
+// sortByDuration annotates results with their travel time from origin and
+// orders them fastest first, keeping the distance order among those without
+// a duration, which go last. It reports false, leaving results in distance
+// order, when durations are unavailable.
+func sortByDuration(ctx context.Context, origin Coordinates, results []PropertyResponse) bool {
+	if travelTimer == nil {
+		return false
+	}
+	if len(results) == 0 {
+		return true
+	}
+	destinations := exactCoordinates(results)
+	ctx, cancel := context.WithTimeout(ctx, travelTimeTimeout)
+	defer cancel()
+	durations, err := travelTimer.TravelTimes(ctx, origin, destinations)
+	if err != nil {
+		log.Printf("Travel times unavailable, sorting by distance: %v", err)
+		return false
+	}
+
+	for i, duration := range durations {
+		if duration >= 0 {
+			minutes := math.Round(duration.Minutes()*10) / 10
+			results[i].DurationMin = &minutes
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i].DurationMin, results[j].DurationMin
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return *a < *b
+	})
+	return true
+}